@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/davhofer/botsky/pkg/botsky"
 	"github.com/davhofer/botsky/pkg/listeners"
@@ -10,9 +11,7 @@ import (
 	"encoding/json"
 	"math/rand/v2"
 	"net/http"
-
-	"github.com/bluesky-social/indigo/api/bsky"
-	"github.com/bluesky-social/indigo/api/chat"
+	"time"
 )
 
 type Slip struct {
@@ -23,80 +22,91 @@ type Response struct {
 	Slip Slip `json:"slip"`
 }
 
-func MentionHandler(ctx context.Context, client *botsky.Client, notifications []*bsky.NotificationListNotifications_Notification) {
-
-	// iterate over all notifications
-	for _, notif := range notifications {
-		// only consider mentions
-		if notif.Reason == "mention" {
-			fmt.Println("mention received")
-			// Uri is the mentioning post
-			post, err := client.GetPost(ctx, notif.Uri)
-			if err != nil {
-				fmt.Println(err)
-			}
+// Handles mentions by starting the advice dialog in the DMs of the author.
+func NewMentionHandler(dialogs *listeners.DialogManager) listeners.EventHandler[*listeners.PostEvent] {
+	return func(ctx context.Context, client *botsky.Client, mention *listeners.PostEvent) error {
+		fmt.Println("mention received")
 
-			textLower := strings.ToLower(post.Text)
-			if strings.Contains(textLower, "advice") || strings.Contains(textLower, "help") {
-				pb := botsky.NewPostBuilder("gotcha, sliding into those DMs").ReplyTo(notif.Uri)
-				_, _, err := client.Post(ctx, pb)
-				if err != nil {
-					fmt.Println(err)
-					return
-				}
+		textLower := strings.ToLower(mention.Post.Text)
+		if !strings.Contains(textLower, "advice") && !strings.Contains(textLower, "help") {
+			pb := botsky.NewPostBuilder("idk what you want from me...\nlet me know if you need some great advice").ReplyTo(mention.Uri)
+			_, _, err := client.Post(ctx, pb)
+			return err
+		}
 
-				// slide into DMs
-				authorDid := notif.Author.Did
+		// find out whether we may message them before replying, a failing handler gets the mention again
+		authorDid := mention.Author.Did
+		convo, err := client.ChatGetConvoForMembers(ctx, []string{authorDid})
+		if errors.Is(botsky.ChatErrorReason(err), botsky.ErrRecipientDisallowsDMs) {
+			// nothing to retry, it's up to them
+			pb := botsky.NewPostBuilder("you gotta let me message you, either follow me or open up DMs in your chat settings, then try again").ReplyTo(mention.Uri)
+			_, _, err := client.Post(ctx, pb)
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("chat error: %v", err)
+		}
 
-				if _, _, err := client.ChatSendMessage(ctx, authorDid, "you ready for some great advice?"); err != nil {
-					fmt.Println("chat error", err)
-					fmt.Println(err.Error())
+		pb := botsky.NewPostBuilder("gotcha, sliding into those DMs").ReplyTo(mention.Uri)
+		if _, _, err := client.Post(ctx, pb); err != nil {
+			return err
+		}
 
-					if strings.Contains(err.Error(), "recipient requires incoming messages to come from someone they follow") {
-						pb := botsky.NewPostBuilder("you gotta let me message you, either follow me or open up DMs in your chat settings, then try again").ReplyTo(notif.Uri)
-						client.Post(ctx, pb)
+		// slide into DMs
+		if err := dialogs.Begin(ctx, client, convo.Id, authorDid, "advice"); err != nil {
+			return fmt.Errorf("chat error: %v", err)
+		}
+		return nil
+	}
+}
 
-					} else if strings.Contains(err.Error(), "recipient has disabled incoming messages") {
-						pb := botsky.NewPostBuilder("you gotta let me message you, change your chat settings and maybe follow me, then try again").ReplyTo(notif.Uri)
-						client.Post(ctx, pb)
-					}
-					return
+// Dialog asking whether the user is ready, then handing out advice.
+var adviceDialog = listeners.Dialog{
+	Name: "advice",
+	Steps: []listeners.DialogStep{
+		{
+			Name:   "ready",
+			Prompt: "you ready for some great advice?",
+			Expect: listeners.ExpectOneOf("yes", "no"),
+			Handle: func(ctx context.Context, client *botsky.Client, d *listeners.DialogContext, reply string) (string, error) {
+				if strings.EqualFold(reply, "no") {
+					return listeners.DialogEnd, d.Reply(ctx, client, "alright, your loss")
 				}
-
 				advice, err := getAdvice()
 				if err != nil {
-					fmt.Println(err)
-					return
+					return listeners.DialogEnd, err
 				}
-				_, _, err = client.ChatSendMessage(ctx, authorDid, "As my mama used to say, "+strings.ToLower(advice))
-				if err != nil {
-					fmt.Println(err)
-					return
-				}
-				client.ChatSendMessage(ctx, authorDid, "you're welcome")
-				client.ChatSendMessage(ctx, authorDid, "alright gotta go, the world needs me")
-
-			} else {
-				pb := botsky.NewPostBuilder("idk what you want from me...\nlet me know if you need some great advice").ReplyTo(notif.Uri)
-				_, _, err := client.Post(ctx, pb)
-				if err != nil {
-					fmt.Println(err)
+				if err := d.Reply(ctx, client, "As my mama used to say, "+strings.ToLower(advice)); err != nil {
+					return listeners.DialogEnd, err
 				}
-			}
+				return "thanks", nil
+			},
+		},
+		{
+			// whatever they say
+			Name: "thanks",
+		},
+	},
+	Timeout:        10 * time.Minute,
+	TimeoutMessage: "alright gotta go, the world needs me",
+	OnComplete: func(ctx context.Context, client *botsky.Client, d *listeners.DialogContext) error {
+		if _, ok := d.Data["thanks"]; ok {
+			return d.Reply(ctx, client, "you're welcome")
 		}
-	}
+		return nil
+	},
 }
 
-func ChatMessageHandler(ctx context.Context, client *botsky.Client, chatElems []*chat.ConvoGetLog_Output_Logs_Elem) {
-	for _, elem := range chatElems {
-		if elem.ConvoDefs_LogCreateMessage != nil && elem.ConvoDefs_LogCreateMessage.Message.ConvoDefs_MessageView.Sender.Did != client.Did {
-			convoId := elem.ConvoDefs_LogCreateMessage.ConvoId
-			reply := "sorry I'm way too busy for you right now"
-			if _, _, err := client.ChatConvoSendMessage(ctx, convoId, reply); err != nil {
-				fmt.Println("Error:", err)
-				continue
-			}
-		}
+func ChatMessageHandler(ctx context.Context, client *botsky.Client, message *listeners.ChatEvent) error {
+	reply := "sorry I'm way too busy for you right now"
+	_, _, err := client.ChatConvoSendMessage(ctx, message.ConvoId, reply)
+	return err
+}
+
+// Print the errors of a listener.
+func logErrors(errs <-chan error) {
+	for err := range errs {
+		fmt.Println("Error:", err)
 	}
 }
 
@@ -152,26 +162,65 @@ func main() {
 
 	botsky.Sleep(1)
 
-	mentionListener := listeners.NewPollingNotificationListener(ctx, client)
+	// remember what was handled, so the bot doesn't reply twice after a restart
+	store := listeners.NewFileCheckpointStore("checkpoints.json")
 
-	if err := mentionListener.RegisterHandler("replyToMentions", MentionHandler); err != nil {
+	// conversations in progress, see adviceDialog
+	dialogs := listeners.NewDialogManager(store)
+	if err := dialogs.Register(adviceDialog); err != nil {
 		fmt.Println(err)
 		return
 	}
-	chatListener := listeners.NewPollingChatListener(ctx, client)
 
-	if err := chatListener.RegisterHandler("replyToChatMsgs", ChatMessageHandler); err != nil {
+	mentionListener := listeners.NewPollingNotificationListener(client, store)
+
+	router, err := listeners.NewNotificationRouter(mentionListener)
+	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	router.OnMention(NewMentionHandler(dialogs))
+	mentionListener.SetHandlerTimeout(time.Minute)
+	go logErrors(mentionListener.Errors())
 
-	mentionListener.Start()
-	chatListener.Start()
+	chatListener := listeners.NewPollingChatListener(client, store, listeners.ChatListenerOptions{})
 
-	botsky.WaitUntilCancel()
+	chatRouter, err := listeners.NewChatRouter(chatListener)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	chatRouter.OnMessage(dialogs.ChatHandler(ChatMessageHandler))
+	chatListener.SetHandlerTimeout(time.Minute)
+	go logErrors(chatListener.Errors())
 
-	mentionListener.Stop()
+	if err := mentionListener.Start(ctx); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := chatListener.Start(ctx); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// say goodbye to users who stopped answering
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := dialogs.ExpireStale(ctx, client); err != nil {
+				fmt.Println("Error:", err)
+			}
+		}
+	}()
 
-	chatListener.Stop()
-	botsky.Sleep(3)
+	botsky.WaitUntilCancel()
+
+	// give running handlers some time to finish
+	stopCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := mentionListener.Stop(stopCtx); err != nil {
+		fmt.Println(err)
+	}
+	if err := chatListener.Stop(stopCtx); err != nil {
+		fmt.Println(err)
+	}
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/davhofer/botsky/pkg/botsky"
+	"github.com/davhofer/botsky/pkg/listeners"
+	"os"
+	"time"
+)
+
+// example bot controlled through commands sent by DM or mention, e.g. "/post hello world"
+// only the owner (set in BOTSKY_OWNER_DID) may create posts
+func chatCommands() {
+	ctx := context.Background()
+
+	defer fmt.Println("botsky is going to bed...")
+
+	handle, appkey, err := botsky.GetEnvCredentials()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	client, err := botsky.NewClient(ctx, handle, appkey)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	err = client.Authenticate(ctx)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Authentication successful")
+
+	startTime := time.Now()
+	commands := listeners.NewCommandRouter()
+
+	err = commands.Register(listeners.Command{
+		Name:        "post",
+		Description: "create a post",
+		Args:        []listeners.CommandArg{{Name: "text", Type: listeners.ArgText}},
+		AllowedDids: []string{os.Getenv("BOTSKY_OWNER_DID")},
+		Handler: func(ctx context.Context, client *botsky.Client, call *listeners.CommandCall) error {
+			_, uri, err := client.Post(ctx, botsky.NewPostBuilder(call.String("text")))
+			if err != nil {
+				return err
+			}
+			return call.Reply(ctx, client, "posted: "+uri)
+		},
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	err = commands.Register(listeners.Command{
+		Name:        "status",
+		Description: "show the uptime of the bot",
+		Handler: func(ctx context.Context, client *botsky.Client, call *listeners.CommandCall) error {
+			return call.Reply(ctx, client, "up since "+time.Since(startTime).Round(time.Second).String())
+		},
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	chatListener := listeners.NewPollingChatListener(client, nil, listeners.ChatListenerOptions{})
+	chatRouter, err := listeners.NewChatRouter(chatListener)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	chatRouter.OnMessage(commands.ChatHandler())
+
+	mentionListener := listeners.NewPollingNotificationListener(client, nil)
+	notifRouter, err := listeners.NewNotificationRouter(mentionListener)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	notifRouter.OnMention(commands.MentionHandler())
+
+	if err := chatListener.Start(ctx); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := mentionListener.Start(ctx); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	botsky.WaitUntilCancel()
+
+	if err := chatListener.Stop(ctx); err != nil {
+		fmt.Println(err)
+	}
+	if err := mentionListener.Stop(ctx); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -5,26 +5,15 @@ import (
 	"fmt"
 	"github.com/davhofer/botsky/pkg/botsky"
 	"github.com/davhofer/botsky/pkg/listeners"
-
-	"github.com/bluesky-social/indigo/api/chat"
 )
 
 // example handler that replies to dms by repeating their content
-// gets called by the listener when there are new messages
-func ExampleChatMessageHandler(ctx context.Context, client *botsky.Client, chatElems []*chat.ConvoGetLog_Output_Logs_Elem) {
-	// iterate over all new chat logs
-	for _, elem := range chatElems {
-		// only consider messages from other people
-		if elem.ConvoDefs_LogCreateMessage != nil && elem.ConvoDefs_LogCreateMessage.Message.ConvoDefs_MessageView.Sender.Did != client.Did {
-			// reply by quoting what they said
-			convoId := elem.ConvoDefs_LogCreateMessage.ConvoId
-			msgText := elem.ConvoDefs_LogCreateMessage.Message.ConvoDefs_MessageView.Text
-			reply := "You said: '" + msgText + "'"
-			if _, _, err := client.ChatConvoSendMessage(ctx, convoId, reply); err != nil {
-				fmt.Println("Error:", err)
-			}
-		}
-	}
+// gets called by the router for every new message (the bots own messages are skipped by the listener)
+func ExampleChatMessageHandler(ctx context.Context, client *botsky.Client, event *listeners.ChatEvent) error {
+	// reply by quoting what they said
+	reply := "You said: '" + event.Message.Text + "'"
+	_, _, err := client.ChatConvoSendMessage(ctx, event.ConvoId, reply)
+	return err
 }
 
 // Note: in my testing, seeing the replies pop up in the web interface often took a few seconds/required me to refresh the page
@@ -52,18 +41,24 @@ func listenerReplyToChatMessages() {
 	}
 	fmt.Println("Authentication successful")
 
-	listener := listeners.NewPollingChatListener(ctx, client)
+	listener := listeners.NewPollingChatListener(client, nil, listeners.ChatListenerOptions{})
 
-	if err := listener.RegisterHandler("replyToChatMsgs", ExampleChatMessageHandler); err != nil {
+	router, err := listeners.NewChatRouter(listener)
+	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	router.OnMessage(ExampleChatMessageHandler)
 
-	listener.Start()
+	if err := listener.Start(ctx); err != nil {
+		fmt.Println(err)
+		return
+	}
 
 	botsky.WaitUntilCancel()
 
-	listener.Stop()
-
-	botsky.Sleep(3)
+	// wait for running handlers to finish
+	if err := listener.Stop(ctx); err != nil {
+		fmt.Println(err)
+	}
 }
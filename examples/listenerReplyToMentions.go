@@ -5,23 +5,25 @@ import (
 	"fmt"
 	"github.com/davhofer/botsky/pkg/botsky"
 	"github.com/davhofer/botsky/pkg/listeners"
-
-	"github.com/bluesky-social/indigo/api/bsky"
 )
 
 // example handler that replies to mentions
 // gets called by the listener
-func ExampleMentionHandler(ctx context.Context, client *botsky.Client, notifications []*bsky.NotificationListNotifications_Notification) {
+func ExampleMentionHandler(ctx context.Context, client *botsky.Client, notifications []*botsky.Notification) error {
 	// iterate over all notifications
 	for _, notif := range notifications {
 		// only consider mentions
-		if notif.Reason == "mention" {
+		if notif.Reason == botsky.NotifReasonMention {
 			// Uri is the mentioning post
 			pb := botsky.NewPostBuilder("hello :)").ReplyTo(notif.Uri)
 			cid, uri, err := client.Post(ctx, pb)
-			fmt.Println("Posted:", cid, uri, err)
+			if err != nil {
+				return err
+			}
+			fmt.Println("Posted:", cid, uri)
 		}
 	}
+	return nil
 }
 
 func listenerReplyToMentions() {
@@ -51,19 +53,29 @@ func listenerReplyToMentions() {
 
 	botsky.Sleep(1)
 
-	listener := listeners.NewPollingNotificationListener(ctx, client)
+	listener := listeners.NewPollingNotificationListener(client, nil)
 
 	if err := listener.RegisterHandler("replyToMentions", ExampleMentionHandler); err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	listener.Start()
-
-	botsky.WaitUntilCancel()
+	// print errors returned by the handler
+	go func() {
+		for err := range listener.Errors() {
+			fmt.Println("Error:", err)
+		}
+	}()
 
-	listener.Stop()
+	if err := listener.Start(ctx); err != nil {
+		fmt.Println(err)
+		return
+	}
 
-	botsky.Sleep(3)
+	botsky.WaitUntilCancel()
 
+	// wait for running handlers to finish
+	if err := listener.Stop(ctx); err != nil {
+		fmt.Println(err)
+	}
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/davhofer/botsky/pkg/botsky"
+)
+
+// example that accepts message requests from followers of the bot and leaves all others
+func manageChatRequests() {
+	ctx := context.Background()
+
+	handle, appkey, err := botsky.GetEnvCredentials()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	client, err := botsky.NewClient(ctx, handle, appkey)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	err = client.Authenticate(ctx)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Authentication successful")
+
+	requests, err := client.ChatListFilteredConvos(ctx, botsky.ConvoFilter{Status: botsky.ConvoStatusRequest})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, convo := range requests {
+		// accept if any other member follows the bot
+		followed := false
+		for _, member := range convo.Members {
+			if member.Did != client.Did && member.Viewer != nil && member.Viewer.FollowedBy != nil {
+				followed = true
+			}
+		}
+
+		if followed {
+			err = client.ChatAcceptConvo(ctx, convo.Id)
+		} else {
+			err = client.ChatLeaveConvo(ctx, convo.Id)
+		}
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Println("handled request", convo.Id, "accepted:", followed)
+	}
+}
@@ -80,13 +80,9 @@ func (c *Client) RefreshSession(ctx context.Context, timer *time.Timer) {
 	defer c.refreshProcessLock.Unlock()
 
 	// check that RefreshJWT is still (for some time) valid
-	auth := c.xrpcClient.Auth
-	if tRemaining, _ := getJwtTimeRemaining(auth.RefreshJwt); tRemaining > 30*time.Second {
-		// set access jwt to refresh jwt
-		auth.AccessJwt = auth.RefreshJwt
-		c.xrpcClient.Auth = auth
+	if canRefreshSession(c.xrpcClient) {
 		// refresh the session
-		session, err := atproto.ServerRefreshSession(ctx, c.xrpcClient)
+		session, err := refreshXrpcSession(ctx, c.xrpcClient)
 
 		if err != nil { // log error if it happened
 			logger.Println("RefreshSession error (ServerRefreshSession):", err)
@@ -104,6 +100,25 @@ func (c *Client) RefreshSession(ctx context.Context, timer *time.Timer) {
 	}
 }
 
+// Whether the refresh JWT of the xrpc client is still (for some time) valid.
+func canRefreshSession(xrpcClient *xrpc.Client) bool {
+	if xrpcClient.Auth == nil || xrpcClient.Auth.RefreshJwt == "" {
+		return false
+	}
+	tRemaining, _ := getJwtTimeRemaining(xrpcClient.Auth.RefreshJwt)
+	return tRemaining > 30*time.Second
+}
+
+// Refresh the session of the xrpc client through its refresh JWT.
+//
+// The refresh JWT is set as the access JWT for the request; the caller has to update the auth info with the result.
+func refreshXrpcSession(ctx context.Context, xrpcClient *xrpc.Client) (*atproto.ServerRefreshSession_Output, error) {
+	auth := xrpcClient.Auth
+	auth.AccessJwt = auth.RefreshJwt
+	xrpcClient.Auth = auth
+	return atproto.ServerRefreshSession(ctx, xrpcClient)
+}
+
 // Authenticates the client with the given credentials and updates its auth info.
 //
 // A background goroutine to automatically refresh the session is started through client.UpdateAuth
@@ -0,0 +1,166 @@
+package botsky
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	util "github.com/bluesky-social/indigo/util"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// Maximum number of operations a PDS accepts in a single applyWrites call.
+const maxWritesPerCall = 200
+
+// Write operation types, as used in WriteResult.Action.
+const (
+	WriteActionCreate = "create"
+	WriteActionUpdate = "update"
+	WriteActionDelete = "delete"
+)
+
+// Result of a single operation of a WriteBatch.
+type WriteResult struct {
+	Action     string // one of WriteActionCreate, WriteActionUpdate, WriteActionDelete
+	Collection string
+	Rkey       string
+	Uri        string // empty for deletes
+	Cid        string // empty for deletes
+}
+
+// A WriteBatch accumulates record creates, updates and deletes (across any collections) in the bots repo
+// and submits them through com.atproto.repo.applyWrites.
+//
+// The server applies at most 200 operations per call atomically, larger batches are split into multiple calls.
+type WriteBatch struct {
+	client *Client
+	writes []*atproto.RepoApplyWrites_Input_Writes_Elem
+	// Optional. If set, the first call fails unless the current repo commit matches this CID.
+	// Every following call is then swapped against the commit produced by the previous one.
+	SwapCommit string
+}
+
+// Create a new, empty write batch for the bots repo.
+func (c *Client) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{
+		client: c,
+	}
+}
+
+// Add a record creation to the batch. Leave rkey empty to let the server generate one.
+func (wb *WriteBatch) Create(collection string, rkey string, record cbg.CBORMarshaler) *WriteBatch {
+	create := &atproto.RepoApplyWrites_Create{
+		Collection: collection,
+		Value:      &lexutil.LexiconTypeDecoder{Val: record},
+	}
+	if rkey != "" {
+		create.Rkey = &rkey
+	}
+	wb.writes = append(wb.writes, &atproto.RepoApplyWrites_Input_Writes_Elem{RepoApplyWrites_Create: create})
+	return wb
+}
+
+// Add a record update (overwrite) to the batch.
+func (wb *WriteBatch) Update(collection string, rkey string, record cbg.CBORMarshaler) *WriteBatch {
+	wb.writes = append(wb.writes, &atproto.RepoApplyWrites_Input_Writes_Elem{
+		RepoApplyWrites_Update: &atproto.RepoApplyWrites_Update{
+			Collection: collection,
+			Rkey:       rkey,
+			Value:      &lexutil.LexiconTypeDecoder{Val: record},
+		},
+	})
+	return wb
+}
+
+// Add a record deletion to the batch.
+func (wb *WriteBatch) Delete(collection string, rkey string) *WriteBatch {
+	wb.writes = append(wb.writes, &atproto.RepoApplyWrites_Input_Writes_Elem{
+		RepoApplyWrites_Delete: &atproto.RepoApplyWrites_Delete{
+			Collection: collection,
+			Rkey:       rkey,
+		},
+	})
+	return wb
+}
+
+// Number of operations currently in the batch.
+func (wb *WriteBatch) Len() int {
+	return len(wb.writes)
+}
+
+// Submit all operations in the batch, in chunks of at most 200 operations per call.
+//
+// Returns one result per operation, in the order they were added. If a call fails, the results of all previously
+// applied chunks are returned together with the error.
+func (wb *WriteBatch) Commit(ctx context.Context) ([]WriteResult, error) {
+	results := make([]WriteResult, 0, len(wb.writes))
+	swapCommit := wb.SwapCommit
+
+	for i := 0; i < len(wb.writes); i += maxWritesPerCall {
+		j := min(i+maxWritesPerCall, len(wb.writes))
+		input := &atproto.RepoApplyWrites_Input{
+			Repo:   wb.client.Did,
+			Writes: wb.writes[i:j],
+		}
+		if swapCommit != "" {
+			input.SwapCommit = &swapCommit
+		}
+
+		output, err := atproto.RepoApplyWrites(ctx, wb.client.xrpcClient, input)
+		if err != nil {
+			return results, fmt.Errorf("WriteBatch.Commit error (RepoApplyWrites): %v", err)
+		}
+
+		for k, write := range input.Writes {
+			var elem *atproto.RepoApplyWrites_Output_Results_Elem
+			if k < len(output.Results) {
+				elem = output.Results[k]
+			}
+			results = append(results, newWriteResult(write, elem))
+		}
+
+		// chain the following chunks onto this commit, but only if the caller asked for swapping at all
+		if swapCommit != "" && output.Commit != nil {
+			swapCommit = output.Commit.Cid
+		}
+	}
+	return results, nil
+}
+
+// Combine an applyWrites operation and its (optional) result element into a WriteResult.
+func newWriteResult(write *atproto.RepoApplyWrites_Input_Writes_Elem, elem *atproto.RepoApplyWrites_Output_Results_Elem) WriteResult {
+	var result WriteResult
+	switch {
+	case write.RepoApplyWrites_Create != nil:
+		result.Action = WriteActionCreate
+		result.Collection = write.RepoApplyWrites_Create.Collection
+		if write.RepoApplyWrites_Create.Rkey != nil {
+			result.Rkey = *write.RepoApplyWrites_Create.Rkey
+		}
+		if elem != nil && elem.RepoApplyWrites_CreateResult != nil {
+			result.Uri = elem.RepoApplyWrites_CreateResult.Uri
+			result.Cid = elem.RepoApplyWrites_CreateResult.Cid
+		}
+	case write.RepoApplyWrites_Update != nil:
+		result.Action = WriteActionUpdate
+		result.Collection = write.RepoApplyWrites_Update.Collection
+		result.Rkey = write.RepoApplyWrites_Update.Rkey
+		if elem != nil && elem.RepoApplyWrites_UpdateResult != nil {
+			result.Uri = elem.RepoApplyWrites_UpdateResult.Uri
+			result.Cid = elem.RepoApplyWrites_UpdateResult.Cid
+		}
+	case write.RepoApplyWrites_Delete != nil:
+		result.Action = WriteActionDelete
+		result.Collection = write.RepoApplyWrites_Delete.Collection
+		result.Rkey = write.RepoApplyWrites_Delete.Rkey
+	}
+
+	// server generated rkeys are only known through the returned uri
+	if result.Rkey == "" && result.Uri != "" {
+		if parsedUri, err := util.ParseAtUri(result.Uri); err == nil {
+			result.Rkey = parsedUri.Rkey
+		}
+	}
+	return result
+}
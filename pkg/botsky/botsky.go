@@ -10,6 +10,7 @@ import (
 
 	"github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/util"
 	"github.com/bluesky-social/indigo/xrpc"
@@ -32,6 +33,8 @@ type Client struct {
 	refreshProcessLock sync.Mutex   // make sure only one auth refresher runs at a time
 	chatClient         *xrpc.Client // client for accessing chat api
 	chatCursor         string
+	chatCursorLock     sync.Mutex         // guards chatCursor, which listeners may update from their own goroutine
+	directory          identity.Directory // resolves DIDs of other accounts, e.g. to find their PDS
 }
 
 // Sets up a new client (not yet authenticated)
@@ -55,6 +58,7 @@ func NewClientWithPds(ctx context.Context, handle string, appkey string, server
 			Host:   string(ApiChat),
 		},
 		chatCursor: "",
+		directory:  identity.DefaultDirectory(),
 	}
 	// resolve own handle to get did. don't need to be authenticated to do that
 	clientDid, err := client.ResolveHandle(ctx, handle)
@@ -133,20 +137,125 @@ func (c *Client) UpdateProfileDescription(ctx context.Context, description strin
 type RichPost struct {
 	bsky.FeedPost
 
-	AuthorDid   string // from *bsky.ActorDefs_ProfileViewBasic
-	Cid         string
-	Uri         string
-	IndexedAt   string
-	LikeCount   int64
-	QuoteCount  int64
-	ReplyCount  int64
-	RepostCount int64
+	AuthorDid         string // from *bsky.ActorDefs_ProfileViewBasic
+	AuthorHandle      string
+	AuthorDisplayName string
+	Author            *bsky.ActorDefs_ProfileViewBasic
+	Cid               string
+	Uri               string
+	IndexedAt         string
+	LikeCount         int64
+	QuoteCount        int64
+	ReplyCount        int64
+	RepostCount       int64
 
-	Images []*bsky.EmbedImages_ViewImage
+	// reply refs, empty if the post is not a reply
+	ReplyParentUri string
+	ReplyRootUri   string
+
+	// embed views, also set for the media of a record with media
+	Images       []*bsky.EmbedImages_ViewImage
+	External     *bsky.EmbedExternal_ViewExternal
+	Video        *bsky.EmbedVideo_View
+	QuotedRecord *bsky.EmbedRecord_View_Record // the quoted post (or other record)
+
+	Labels     []*atproto.LabelDefs_Label
+	Threadgate *bsky.FeedDefs_ThreadgateView
+
+	// state of the post for the bot, empty if the bot didn't like/repost it
+	ViewerLikeUri   string
+	ViewerRepostUri string
+
+	client *Client // client the post was loaded with, used e.g. to download media
+}
+
+// Build a RichPost from a postView. Every optional field of the view may be missing.
+func (c *Client) newRichPost(postView *bsky.FeedDefs_PostView) (*RichPost, error) {
+	if postView.Record == nil || postView.Record.Val == nil {
+		return nil, fmt.Errorf("newRichPost error: post %s has no record", postView.Uri)
+	}
+	var feedPost bsky.FeedPost
+	if record, ok := postView.Record.Val.(*bsky.FeedPost); ok {
+		feedPost = *record
+	} else if err := decodeRecordAsLexicon(postView.Record, &feedPost); err != nil {
+		return nil, fmt.Errorf("newRichPost error (DecodeRecordAsLexicon): %v", err)
+	}
+
+	post := &RichPost{
+		FeedPost:    feedPost,
+		Author:      postView.Author,
+		Cid:         postView.Cid,
+		Uri:         postView.Uri,
+		IndexedAt:   postView.IndexedAt,
+		LikeCount:   derefInt64(postView.LikeCount),
+		QuoteCount:  derefInt64(postView.QuoteCount),
+		ReplyCount:  derefInt64(postView.ReplyCount),
+		RepostCount: derefInt64(postView.RepostCount),
+		Labels:      postView.Labels,
+		Threadgate:  postView.Threadgate,
+		client:      c,
+	}
+
+	if author := postView.Author; author != nil {
+		post.AuthorDid = author.Did
+		post.AuthorHandle = author.Handle
+		if author.DisplayName != nil {
+			post.AuthorDisplayName = *author.DisplayName
+		}
+	}
+
+	if reply := feedPost.Reply; reply != nil {
+		if reply.Parent != nil {
+			post.ReplyParentUri = reply.Parent.Uri
+		}
+		if reply.Root != nil {
+			post.ReplyRootUri = reply.Root.Uri
+		}
+	}
+
+	if embed := postView.Embed; embed != nil {
+		post.setMediaViews(embed.EmbedImages_View, embed.EmbedVideo_View, embed.EmbedExternal_View)
+		recordView := embed.EmbedRecord_View
+		if withMedia := embed.EmbedRecordWithMedia_View; withMedia != nil {
+			recordView = withMedia.Record
+			if media := withMedia.Media; media != nil {
+				post.setMediaViews(media.EmbedImages_View, media.EmbedVideo_View, media.EmbedExternal_View)
+			}
+		}
+		if recordView != nil {
+			post.QuotedRecord = recordView.Record
+		}
+	}
+
+	if viewer := postView.Viewer; viewer != nil {
+		if viewer.Like != nil {
+			post.ViewerLikeUri = *viewer.Like
+		}
+		if viewer.Repost != nil {
+			post.ViewerRepostUri = *viewer.Repost
+		}
+	}
+	return post, nil
+}
+
+// Set the views of embedded media, ignoring missing ones.
+func (p *RichPost) setMediaViews(images *bsky.EmbedImages_View, video *bsky.EmbedVideo_View, external *bsky.EmbedExternal_View) {
+	if images != nil {
+		p.Images = images.Images
+	}
+	if video != nil {
+		p.Video = video
+	}
+	if external != nil {
+		p.External = external.External
+	}
 }
 
 // Load Bluesky AppView postViews for the given repo/user.
 //
+// The posts are loaded from the repo and hydrated in batches, so this doesn't include reposts. For reading what an
+// account posted, GetAuthorFeed is usually faster.
+//
 // Set limit = -1 in order to get all postViews.
 func (c *Client) GetPostViews(ctx context.Context, handleOrDid string, limit int) ([]*bsky.FeedDefs_PostView, error) {
 	// get all post uris
@@ -182,22 +291,11 @@ func (c *Client) GetPosts(ctx context.Context, handleOrDid string, limit int) ([
 
 	posts := make([]*RichPost, 0, len(postViews))
 	for _, postView := range postViews {
-		var feedPost bsky.FeedPost
-		if err := decodeRecordAsLexicon(postView.Record, &feedPost); err != nil {
-			return nil, fmt.Errorf("GetPosts error (DecodeRecordAsLexicon): %v", err)
+		post, err := c.newRichPost(postView)
+		if err != nil {
+			return nil, fmt.Errorf("GetPosts error: %v", err)
 		}
-		posts = append(posts, &RichPost{
-			FeedPost:    feedPost,
-			AuthorDid:   postView.Author.Did,
-			Cid:         postView.Cid,
-			Uri:         postView.Uri,
-			IndexedAt:   postView.IndexedAt,
-			LikeCount:   *postView.LikeCount,
-			QuoteCount:  *postView.QuoteCount,
-			ReplyCount:  *postView.ReplyCount,
-			RepostCount: *postView.RepostCount,
-		})
-
+		posts = append(posts, post)
 	}
 	return posts, nil
 }
@@ -211,33 +309,12 @@ func (c *Client) GetPost(ctx context.Context, postUri string) (RichPost, error)
 	if len(results.Posts) == 0 {
 		return RichPost{}, fmt.Errorf("GetPost error: No post with the given uri found")
 	}
-	postView := results.Posts[0]
 
-	var feedPost bsky.FeedPost
-	err = decodeRecordAsLexicon(postView.Record, &feedPost)
+	post, err := c.newRichPost(results.Posts[0])
 	if err != nil {
-		return RichPost{}, fmt.Errorf("GetPost error (DecodeRecordAsLexicon): %v", err)
-	}
-
-	var images []*bsky.EmbedImages_ViewImage
-	if postView.Embed != nil && postView.Embed.EmbedImages_View != nil {
-		images = postView.Embed.EmbedImages_View.Images
-	}
-
-	post := RichPost{
-		FeedPost:    feedPost,
-		Images:      images,
-		AuthorDid:   postView.Author.Did,
-		Cid:         postView.Cid,
-		Uri:         postView.Uri,
-		IndexedAt:   postView.IndexedAt,
-		LikeCount:   *postView.LikeCount,
-		QuoteCount:  *postView.QuoteCount,
-		ReplyCount:  *postView.ReplyCount,
-		RepostCount: *postView.RepostCount,
+		return RichPost{}, fmt.Errorf("GetPost error: %v", err)
 	}
-
-	return post, nil
+	return *post, nil
 }
 
 type Profile struct {
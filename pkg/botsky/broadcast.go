@@ -0,0 +1,293 @@
+package botsky
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/chat"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// Reasons for failed deliveries of a broadcast, see BroadcastError.
+var (
+	ErrRecipientDisallowsDMs = errors.New("recipient doesn't accept messages from the bot")
+	ErrRecipientBlocked      = errors.New("recipient is blocked or blocks the bot")
+	ErrRecipientNotFound     = errors.New("recipient not found")
+)
+
+// Options for a broadcast.
+type BroadcastOptions struct {
+	Interval   time.Duration // delay between two messages, defaults to 1s
+	MaxRetries int           // retries per recipient when rate limited, defaults to 3
+	OptOuts    OptOutStore   // optional, recipients that opted out are skipped
+}
+
+// Result of a broadcast.
+type BroadcastReport struct {
+	Sent    []string          // DIDs of the recipients that received the message
+	Skipped []string          // handles/DIDs of the recipients that opted out
+	Failed  []*BroadcastError // failed deliveries
+}
+
+// Failed delivery of a broadcast to a recipient.
+//
+// Use errors.Is with the ErrRecipient* errors to check the reason, e.g. errors.Is(err, ErrRecipientBlocked).
+type BroadcastError struct {
+	Recipient string // handle or DID of the recipient
+	Reason    error  // one of the ErrRecipient* errors, or nil if the failure is not classified
+	Err       error  // the underlying error
+}
+
+func (e *BroadcastError) Error() string {
+	if e.Reason != nil {
+		return fmt.Sprintf("%s: %v (%v)", e.Recipient, e.Reason, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Recipient, e.Err)
+}
+
+func (e *BroadcastError) Unwrap() []error {
+	return []error{e.Reason, e.Err}
+}
+
+// Send the same message to each of the recipients individually (in their 1:1 conversations with the bot).
+// Duplicate recipients (also a handle and the DID of the same account) only get the message once.
+//
+// Messages are throttled to stay under the chat rate limits, so this can take a while for many recipients. Failed
+// deliveries don't stop the broadcast and are listed in the report. An error is only returned if the broadcast as a
+// whole failed (invalid message, ctx cancelled), together with the report up to then.
+func (c *Client) ChatBroadcast(ctx context.Context, recipients []string, mb *MessageBuilder, opts BroadcastOptions) (*BroadcastReport, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 3
+	}
+	report := &BroadcastReport{}
+
+	// the message is the same for everyone
+	message, err := c.buildMessageInput(ctx, mb)
+	if err != nil {
+		return report, fmt.Errorf("ChatBroadcast error: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	attempted := false
+	for _, recipient := range recipients {
+		if seen[recipient] {
+			continue
+		}
+		seen[recipient] = true
+
+		did, err := c.ResolveHandle(ctx, recipient)
+		if err != nil {
+			report.Failed = append(report.Failed, &BroadcastError{Recipient: recipient, Reason: ErrRecipientNotFound, Err: err})
+			continue
+		}
+		if did != recipient {
+			if seen[did] {
+				continue
+			}
+			seen[did] = true
+		}
+
+		if opts.OptOuts != nil {
+			optedOut, err := opts.OptOuts.IsOptedOut(ctx, did)
+			if err != nil {
+				return report, fmt.Errorf("ChatBroadcast error (IsOptedOut): %v", err)
+			}
+			if optedOut {
+				report.Skipped = append(report.Skipped, recipient)
+				continue
+			}
+		}
+
+		// only throttle between actual sends, not for skipped recipients
+		if attempted {
+			if err := sleepCtx(ctx, opts.Interval); err != nil {
+				return report, fmt.Errorf("ChatBroadcast error: %v", err)
+			}
+		}
+		attempted = true
+
+		if err := c.broadcastTo(ctx, did, message, opts); err != nil {
+			if ctx.Err() != nil {
+				return report, fmt.Errorf("ChatBroadcast error: %v", ctx.Err())
+			}
+			report.Failed = append(report.Failed, &BroadcastError{Recipient: recipient, Reason: ChatErrorReason(err), Err: err})
+			continue
+		}
+		report.Sent = append(report.Sent, did)
+	}
+	return report, nil
+}
+
+// Send the message to a single recipient, retrying if rate limited.
+func (c *Client) broadcastTo(ctx context.Context, did string, message *chat.ConvoDefs_MessageInput, opts BroadcastOptions) error {
+	for attempt := 0; ; attempt++ {
+		err := func() error {
+			convoOutput, err := chat.ConvoGetConvoForMembers(ctx, c.chatClient, []string{did})
+			if err != nil {
+				return err
+			}
+			input := chat.ConvoSendMessage_Input{ConvoId: convoOutput.Convo.Id, Message: message}
+			_, err = chat.ConvoSendMessage(ctx, c.chatClient, &input)
+			return err
+		}()
+
+		var xrpcErr *xrpc.Error
+		if err == nil || !errors.As(err, &xrpcErr) || !xrpcErr.IsThrottled() || attempt >= opts.MaxRetries {
+			return err
+		}
+
+		// wait until the rate limit resets
+		wait := time.Duration(attempt+1) * 30 * time.Second
+		if xrpcErr.Ratelimit != nil && time.Until(xrpcErr.Ratelimit.Reset) > 0 {
+			wait = time.Until(xrpcErr.Ratelimit.Reset)
+		}
+		if err := sleepCtx(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// Map the error of a failed chat request to one of the ErrRecipient* errors, or nil if unknown.
+//
+// The reason is determined by the XRPC error name returned by the chat service.
+func ChatErrorReason(err error) error {
+	var xrpcErr *xrpc.Error
+	if !errors.As(err, &xrpcErr) {
+		return nil
+	}
+	var details *xrpc.XRPCError
+	if errors.As(xrpcErr, &details) {
+		switch details.ErrStr {
+		case "AccountNotFound", "AccountDeactivated", "AccountTakedown", "AccountSuspended", "RepoNotFound", "RepoDeactivated", "RepoTakendown", "RecipientNotFound":
+			return ErrRecipientNotFound
+		case "BlockedActor", "BlockedByActor":
+			return ErrRecipientBlocked
+		case "MessagesDisabled", "NotFollowedBySender":
+			return ErrRecipientDisallowsDMs
+		}
+	}
+	if xrpcErr.StatusCode == http.StatusNotFound {
+		return ErrRecipientNotFound
+	}
+	return nil
+}
+
+// Sleep for the given duration, or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Stores which accounts opted out of broadcasts.
+type OptOutStore interface {
+	IsOptedOut(ctx context.Context, did string) (bool, error)
+	SetOptedOut(ctx context.Context, did string, optedOut bool) error
+}
+
+// OptOutStore keeping the opt-outs in memory only, i.e. they are lost when the process exits.
+type MemoryOptOutStore struct {
+	optedOut map[string]bool
+	mutex    sync.Mutex
+}
+
+// Returns an empty MemoryOptOutStore.
+func NewMemoryOptOutStore() *MemoryOptOutStore {
+	return &MemoryOptOutStore{optedOut: make(map[string]bool)}
+}
+
+func (s *MemoryOptOutStore) IsOptedOut(ctx context.Context, did string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.optedOut[did], nil
+}
+
+func (s *MemoryOptOutStore) SetOptedOut(ctx context.Context, did string, optedOut bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if optedOut {
+		s.optedOut[did] = true
+	} else {
+		delete(s.optedOut, did)
+	}
+	return nil
+}
+
+// OptOutStore keeping the opt-outs in a JSON file (a list of DIDs), so they survive restarts. The file is rewritten
+// atomically on every change.
+type FileOptOutStore struct {
+	Path  string
+	mutex sync.Mutex
+}
+
+// Returns a FileOptOutStore using the file at the given path. The file is created on the first opt-out.
+func NewFileOptOutStore(path string) *FileOptOutStore {
+	return &FileOptOutStore{Path: path}
+}
+
+func (s *FileOptOutStore) IsOptedOut(ctx context.Context, did string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	dids, err := s.read()
+	if err != nil {
+		return false, fmt.Errorf("IsOptedOut error: %v", err)
+	}
+	return slices.Contains(dids, did), nil
+}
+
+func (s *FileOptOutStore) SetOptedOut(ctx context.Context, did string, optedOut bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	dids, err := s.read()
+	if err != nil {
+		return fmt.Errorf("SetOptedOut error: %v", err)
+	}
+	if slices.Contains(dids, did) == optedOut {
+		return nil
+	}
+	if optedOut {
+		dids = append(dids, did)
+	} else {
+		dids = slices.DeleteFunc(dids, func(d string) bool { return d == did })
+	}
+
+	data, err := json.MarshalIndent(dids, "", "  ")
+	if err != nil {
+		return fmt.Errorf("SetOptedOut error (MarshalIndent): %v", err)
+	}
+	if err := WriteFileAtomic(s.Path, data); err != nil {
+		return fmt.Errorf("SetOptedOut error: %v", err)
+	}
+	return nil
+}
+
+// Read the opted out DIDs. A missing file means no opt-outs.
+func (s *FileOptOutStore) read() ([]string, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read error (ReadFile): %v", err)
+	}
+	var dids []string
+	if err := json.Unmarshal(data, &dids); err != nil {
+		return nil, fmt.Errorf("read error (Unmarshal): %v", err)
+	}
+	return dids, nil
+}
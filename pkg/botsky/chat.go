@@ -3,8 +3,13 @@ package botsky
 import (
 	"context"
 	"fmt"
+	"iter"
+	"slices"
 
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/api/chat"
+	"github.com/rivo/uniseg"
 )
 
 // Update for the given account whether it can initiate DMs or not.
@@ -39,6 +44,8 @@ func (c *Client) ChatConvoUpdateRead(ctx context.Context, convoId string, messag
 }
 
 // Get the conversation including exactly the provided accounts, or create a new one if it doesn't exist.
+//
+// Fails with ErrRecipientDisallowsDMs as ChatErrorReason if a member doesn't accept messages from the bot.
 func (c *Client) ChatGetConvoForMembers(ctx context.Context, handlesOrDids []string) (*chat.ConvoDefs_ConvoView, error) {
 	var dids []string
 	for _, handleOrDid := range handlesOrDids {
@@ -52,7 +59,8 @@ func (c *Client) ChatGetConvoForMembers(ctx context.Context, handlesOrDids []str
 	// TODO: does this require a handle?
 	convoOutput, err := chat.ConvoGetConvoForMembers(ctx, c.chatClient, dids)
 	if err != nil {
-		return nil, fmt.Errorf("ChatGetConvoForMembers error: %v", err)
+		// wrapped, so the reason can be checked with ChatErrorReason
+		return nil, fmt.Errorf("ChatGetConvoForMembers error: %w", err)
 	}
 	return convoOutput.Convo, nil
 }
@@ -67,13 +75,93 @@ func (c *Client) ChatGetConvo(ctx context.Context, convoId string) (*chat.ConvoD
 	return convoOutput.Convo, nil
 }
 
-// Send a text message to the given conversation.
+// Maximum length of chat messages, in graphemes and bytes.
+const (
+	chatMessageMaxGraphemes = 1000
+	chatMessageMaxBytes     = 10000
+)
+
+// The MessageBuilder is used to prepare chat messages with links, mentions and embedded posts.
+type MessageBuilder struct {
+	Text         string
+	InlineLinks  []InlineLink
+	EmbedPostUri string
+}
+
+// Create a new chat message with text. Links and mentions in the text are detected automatically.
+func NewMessageBuilder(text string) *MessageBuilder {
+	return &MessageBuilder{
+		Text: text,
+	}
+}
+
+// Add hyperlinks to substrings of the message text.
+func (mb *MessageBuilder) AddInlineLinks(links []InlineLink) *MessageBuilder {
+	mb.InlineLinks = append(mb.InlineLinks, links...)
+	return mb
+}
+
+// Embed (share) a post in the message.
+func (mb *MessageBuilder) AddEmbedPost(postUri string) *MessageBuilder {
+	mb.EmbedPostUri = postUri
+	return mb
+}
+
+// Build the message input, resolving mentions and the embedded post.
+func (c *Client) buildMessageInput(ctx context.Context, mb *MessageBuilder) (*chat.ConvoDefs_MessageInput, error) {
+	if n := uniseg.GraphemeClusterCount(mb.Text); n > chatMessageMaxGraphemes {
+		return nil, fmt.Errorf("message too long: %d graphemes (max %d)", n, chatMessageMaxGraphemes)
+	}
+	if len(mb.Text) > chatMessageMaxBytes {
+		return nil, fmt.Errorf("message too long: %d bytes (max %d)", len(mb.Text), chatMessageMaxBytes)
+	}
+
+	facets, err := buildFacets(mb.Text, c.detectMentions(ctx, mb.Text), mb.InlineLinks, false)
+	if err != nil {
+		return nil, err
+	}
+	input := &chat.ConvoDefs_MessageInput{
+		Text:   mb.Text,
+		Facets: facets,
+	}
+
+	if mb.EmbedPostUri != "" {
+		_, cid, err := c.RepoGetPostAndCid(ctx, mb.EmbedPostUri)
+		if err != nil {
+			return nil, fmt.Errorf("error when getting embedded post: %v", err)
+		}
+		input.Embed = &chat.ConvoDefs_MessageInput_Embed{
+			EmbedRecord: &bsky.EmbedRecord{
+				LexiconTypeID: "app.bsky.embed.record",
+				Record: &atproto.RepoStrongRef{
+					LexiconTypeID: "com.atproto.repo.strongRef",
+					Cid:           cid,
+					Uri:           mb.EmbedPostUri,
+				},
+			},
+		}
+	}
+	return input, nil
+}
+
+// Send a text message to the given conversation. Links and mentions in the text are detected automatically.
+//
+// Returns the id and rev of the sent message.
 func (c *Client) ChatConvoSendMessage(ctx context.Context, convoId string, message string) (string, string, error) {
+	return c.ChatConvoSendRichMessage(ctx, convoId, NewMessageBuilder(message))
+}
+
+// Send a message built with a MessageBuilder to the given conversation.
+//
+// Returns the id and rev of the sent message.
+func (c *Client) ChatConvoSendRichMessage(ctx context.Context, convoId string, mb *MessageBuilder) (string, string, error) {
+	message, err := c.buildMessageInput(ctx, mb)
+	if err != nil {
+		return "", "", fmt.Errorf("ChatSendMessage error: %v", err)
+	}
 	input := chat.ConvoSendMessage_Input{
 		ConvoId: convoId,
-		Message: &chat.ConvoDefs_MessageInput{
-			Text: message,
-		},
+		Message: message,
 	}
 	msgView, err := chat.ConvoSendMessage(ctx, c.chatClient, &input)
 	if err != nil {
@@ -82,78 +170,206 @@ func (c *Client) ChatConvoSendMessage(ctx context.Context, convoId string, messa
 	return msgView.Id, msgView.Rev, nil
 }
 
-// List all conversations.
-func (c *Client) ChatListConvos(ctx context.Context) ([]*chat.ConvoDefs_ConvoView, error) {
-	var convos []*chat.ConvoDefs_ConvoView
-	cursor, lastId := "", ""
+// Add an emoji reaction to a message. The reaction must be a single emoji.
+func (c *Client) ChatAddReaction(ctx context.Context, convoId string, messageId string, emoji string) error {
+	if uniseg.GraphemeClusterCount(emoji) != 1 {
+		return fmt.Errorf("ChatAddReaction error: reaction must be a single emoji, got '%s'", emoji)
+	}
+	input := chat.ConvoAddReaction_Input{
+		ConvoId:   convoId,
+		MessageId: messageId,
+		Value:     emoji,
+	}
+	if _, err := chat.ConvoAddReaction(ctx, c.chatClient, &input); err != nil {
+		return fmt.Errorf("ChatAddReaction error: %v", err)
+	}
+	return nil
+}
+
+// Remove an emoji reaction of the bot from a message.
+func (c *Client) ChatRemoveReaction(ctx context.Context, convoId string, messageId string, emoji string) error {
+	input := chat.ConvoRemoveReaction_Input{
+		ConvoId:   convoId,
+		MessageId: messageId,
+		Value:     emoji,
+	}
+	if _, err := chat.ConvoRemoveReaction(ctx, c.chatClient, &input); err != nil {
+		return fmt.Errorf("ChatRemoveReaction error: %v", err)
+	}
+	return nil
+}
 
-	// iterate until we got all convos
-	for {
-		// query repo for collection with updated cursor
-		output, err := chat.ConvoListConvos(ctx, c.chatClient, cursor, 100, "", "")
+// Status of a conversation.
+const (
+	ConvoStatusRequest  = "request"  // message request the bot hasn't accepted yet
+	ConvoStatusAccepted = "accepted" // conversation the bot has accepted or started
+)
+
+// Filter for listing conversations.
+type ConvoFilter struct {
+	Status     string // ConvoStatusRequest or ConvoStatusAccepted, empty for all conversations
+	UnreadOnly bool   // only list conversations with unread messages
+}
+
+// Iterate over all conversations of the bot.
+//
+// Pages are fetched lazily. Pass a Cursor to resume a previous iteration, or nil to start at the beginning.
+func (c *Client) IterConvos(ctx context.Context, cursor *Cursor) iter.Seq2[*chat.ConvoDefs_ConvoView, error] {
+	return c.IterFilteredConvos(ctx, ConvoFilter{}, cursor)
+}
+
+// Iterate over the conversations of the bot matching the filter.
+//
+// Pages are fetched lazily. Pass a Cursor to resume a previous iteration, or nil to start at the beginning.
+func (c *Client) IterFilteredConvos(ctx context.Context, filter ConvoFilter, cursor *Cursor) iter.Seq2[*chat.ConvoDefs_ConvoView, error] {
+	readState := ""
+	if filter.UnreadOnly {
+		readState = "unread"
+	}
+	return paginate(ctx, cursor, func(ctx context.Context, cursor string) ([]*chat.ConvoDefs_ConvoView, *string, error) {
+		output, err := chat.ConvoListConvos(ctx, c.chatClient, cursor, pageSize, readState, filter.Status)
 		if err != nil {
-			return nil, fmt.Errorf("ChatListConvos error: %v", err)
+			return nil, nil, fmt.Errorf("IterConvos error (ConvoListConvos): %v", err)
 		}
+		return output.Convos, output.Cursor, nil
+	})
+}
 
-		// stop if no records returned
-		// or we get one we've already seen (maybe a repo doesn't support cursor?)
-		if len(output.Convos) == 0 || lastId == output.Convos[len(output.Convos)-1].Id {
-			break
-		}
-		lastId = output.Convos[len(output.Convos)-1].Id
+// List all conversations.
+func (c *Client) ChatListConvos(ctx context.Context) ([]*chat.ConvoDefs_ConvoView, error) {
+	convos, err := collect(c.IterConvos(ctx, nil), -1)
+	if err != nil {
+		return nil, fmt.Errorf("ChatListConvos error: %v", err)
+	}
+	return convos, nil
+}
 
-		// store all record uris
-		convos = append(convos, output.Convos...)
+// List all conversations matching the filter, e.g. all open message requests.
+func (c *Client) ChatListFilteredConvos(ctx context.Context, filter ConvoFilter) ([]*chat.ConvoDefs_ConvoView, error) {
+	convos, err := collect(c.IterFilteredConvos(ctx, filter, nil), -1)
+	if err != nil {
+		return nil, fmt.Errorf("ChatListFilteredConvos error: %v", err)
+	}
+	return convos, nil
+}
 
-		// update cursor
-		cursor = *output.Cursor
+// Accept a message request.
+func (c *Client) ChatAcceptConvo(ctx context.Context, convoId string) error {
+	input := chat.ConvoAcceptConvo_Input{ConvoId: convoId}
+	if _, err := chat.ConvoAcceptConvo(ctx, c.chatClient, &input); err != nil {
+		return fmt.Errorf("ChatAcceptConvo error: %v", err)
 	}
+	return nil
+}
 
-	return convos, nil
+// Leave a conversation (or reject a message request).
+func (c *Client) ChatLeaveConvo(ctx context.Context, convoId string) error {
+	input := chat.ConvoLeaveConvo_Input{ConvoId: convoId}
+	if _, err := chat.ConvoLeaveConvo(ctx, c.chatClient, &input); err != nil {
+		return fmt.Errorf("ChatLeaveConvo error: %v", err)
+	}
+	return nil
 }
 
-// Get all messages in the given conversation.
-func (c *Client) ChatConvoGetMessages(ctx context.Context, convoId string, limit int) ([]*chat.ConvoDefs_MessageView, error) {
+// Mute a conversation.
+func (c *Client) ChatMuteConvo(ctx context.Context, convoId string) error {
+	input := chat.ConvoMuteConvo_Input{ConvoId: convoId}
+	if _, err := chat.ConvoMuteConvo(ctx, c.chatClient, &input); err != nil {
+		return fmt.Errorf("ChatMuteConvo error: %v", err)
+	}
+	return nil
+}
 
-	var messages []*chat.ConvoDefs_MessageView
-	cursor, lastId := "", ""
+// Unmute a conversation.
+func (c *Client) ChatUnmuteConvo(ctx context.Context, convoId string) error {
+	input := chat.ConvoUnmuteConvo_Input{ConvoId: convoId}
+	if _, err := chat.ConvoUnmuteConvo(ctx, c.chatClient, &input); err != nil {
+		return fmt.Errorf("ChatUnmuteConvo error: %v", err)
+	}
+	return nil
+}
 
-	// iterate until we got all records
-	for {
-		// query repo for collection with updated cursor
-		output, err := chat.ConvoGetMessages(ctx, c.chatClient, convoId, cursor, 100)
+// Delete a message for the bot. The other members of the conversation still see it.
+func (c *Client) ChatDeleteMessageForSelf(ctx context.Context, convoId string, messageId string) error {
+	input := chat.ConvoDeleteMessageForSelf_Input{ConvoId: convoId, MessageId: messageId}
+	if _, err := chat.ConvoDeleteMessageForSelf(ctx, c.chatClient, &input); err != nil {
+		return fmt.Errorf("ChatDeleteMessageForSelf error: %v", err)
+	}
+	return nil
+}
+
+// Maximum number of messages per sendMessageBatch call.
+const chatBatchSize = 100
+
+// A message to send with ChatSendMessageBatch.
+type ChatBatchItem struct {
+	ConvoId string
+	Message *MessageBuilder
+}
+
+// Send multiple messages (e.g. to different conversations) in as few requests as possible.
+//
+// Returns the ids of the sent messages, in the order of the items. If a request fails, the ids of the messages sent
+// up to then are returned together with the error.
+func (c *Client) ChatSendMessageBatch(ctx context.Context, items []ChatBatchItem) ([]string, error) {
+	var ids []string
+	for chunk := range slices.Chunk(items, chatBatchSize) {
+		input := chat.ConvoSendMessageBatch_Input{}
+		for _, item := range chunk {
+			message, err := c.buildMessageInput(ctx, item.Message)
+			if err != nil {
+				return ids, fmt.Errorf("ChatSendMessageBatch error: %v", err)
+			}
+			input.Items = append(input.Items, &chat.ConvoSendMessageBatch_BatchItem{ConvoId: item.ConvoId, Message: message})
+		}
+		output, err := chat.ConvoSendMessageBatch(ctx, c.chatClient, &input)
 		if err != nil {
-			return nil, fmt.Errorf("ChatGetConvoMessages error: %v", err)
+			return ids, fmt.Errorf("ChatSendMessageBatch error: %v", err)
 		}
-
-		// stop if no records returned
-		// or we get one we've already seen (maybe a repo doesn't support cursor?)
-		if len(output.Messages) == 0 || lastId == output.Messages[len(output.Messages)-1].ConvoDefs_MessageView.Id {
-			break
+		for _, msgView := range output.Items {
+			ids = append(ids, msgView.Id)
 		}
-		lastId = output.Messages[len(output.Messages)-1].ConvoDefs_MessageView.Id
-		// store all record uris
-		for _, msg := range output.Messages {
-			messages = append(messages, msg.ConvoDefs_MessageView)
+	}
+	return ids, nil
+}
+
+// Iterate over the messages in the given conversation, newest first. Deleted messages are skipped.
+//
+// Pages are fetched lazily. Pass a Cursor to resume a previous iteration, or nil to start at the beginning.
+func (c *Client) IterMessages(ctx context.Context, convoId string, cursor *Cursor) iter.Seq2[*chat.ConvoDefs_MessageView, error] {
+	elems := paginate(ctx, cursor, func(ctx context.Context, cursor string) ([]*chat.ConvoGetMessages_Output_Messages_Elem, *string, error) {
+		output, err := chat.ConvoGetMessages(ctx, c.chatClient, convoId, cursor, pageSize)
+		if err != nil {
+			return nil, nil, fmt.Errorf("IterMessages error (ConvoGetMessages): %v", err)
 		}
+		return output.Messages, output.Cursor, nil
+	})
 
-		// if we have more records than the requested limit, stop
-		// limit -1 indicates no upper limit, i.e. get all record
-		if limit != -1 && len(messages) >= limit {
-			break
+	return func(yield func(*chat.ConvoDefs_MessageView, error) bool) {
+		for elem, err := range elems {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if elem.ConvoDefs_MessageView == nil {
+				continue
+			}
+			if !yield(elem.ConvoDefs_MessageView, nil) {
+				return
+			}
 		}
-		// update cursor
-		cursor = *output.Cursor
 	}
+}
 
-	// don't return more than the requested limit
-	var end int
-	if limit == -1 {
-		end = len(messages)
-	} else {
-		end = min(len(messages), limit)
+// Get all messages in the given conversation.
+//
+// Set limit = -1 in order to get all messages.
+func (c *Client) ChatConvoGetMessages(ctx context.Context, convoId string, limit int) ([]*chat.ConvoDefs_MessageView, error) {
+	messages, err := collect(c.IterMessages(ctx, convoId, nil), limit)
+	if err != nil {
+		return nil, fmt.Errorf("ChatGetConvoMessages error: %v", err)
 	}
-	return messages[:end], nil
+	return messages, nil
 }
 
 // Send a message to the given account. Uses the existing chat with that account if it exists, or creates a new one if it doesn't.
@@ -167,6 +383,16 @@ func (c *Client) ChatSendMessage(ctx context.Context, handleOrDid string, messag
 	return c.ChatConvoSendMessage(ctx, convo.Id, message)
 }
 
+// Send a message built with a MessageBuilder to the given account. Uses the existing chat with that account if it exists, or creates a new one if it doesn't.
+func (c *Client) ChatSendRichMessage(ctx context.Context, handleOrDid string, mb *MessageBuilder) (string, string, error) {
+	convo, err := c.ChatGetConvoForMembers(ctx, []string{handleOrDid})
+	if err != nil {
+		return "", "", err
+	}
+
+	return c.ChatConvoSendRichMessage(ctx, convo.Id, mb)
+}
+
 // Send a group message to the given list of accounts. Uses the existing group chat with these accounts if it exists, or creates a new one if it doesn't.
 func (c *Client) ChatSendGroupMessage(ctx context.Context, handlesOrDids []string, message string) (string, string, error) {
 	convo, err := c.ChatGetConvoForMembers(ctx, handlesOrDids)
@@ -177,12 +403,43 @@ func (c *Client) ChatSendGroupMessage(ctx context.Context, handlesOrDids []strin
 	return c.ChatConvoSendMessage(ctx, convo.Id, message)
 }
 
-// Get all chat logs since the last cursor update (maintained internally).
+// Get the chat logs (new messages, reactions, conversations, ...) after the given cursor.
+//
+// Returns the logs and the cursor to pass to the next call. If there are no new logs, the cursor is returned unchanged.
+func (c *Client) ChatGetLogs(ctx context.Context, cursor string) ([]*chat.ConvoGetLog_Output_Logs_Elem, string, error) {
+	logOutput, err := chat.ConvoGetLog(ctx, c.chatClient, cursor)
+	if err != nil {
+		return nil, cursor, fmt.Errorf("ChatGetLogs error (ConvoGetLog): %v", err)
+	}
+	if logOutput.Cursor != nil && *logOutput.Cursor != "" {
+		cursor = *logOutput.Cursor
+	}
+	return logOutput.Logs, cursor, nil
+}
+
+// Get all chat logs since the last cursor update (maintained internally, see ChatCursor).
 func (c *Client) ChatGetRecentLogs(ctx context.Context) ([]*chat.ConvoGetLog_Output_Logs_Elem, error) {
-	logOutput, err := chat.ConvoGetLog(ctx, c.chatClient, c.chatCursor)
+	// hold the lock during the request, so concurrent calls don't get the same logs
+	c.chatCursorLock.Lock()
+	defer c.chatCursorLock.Unlock()
+	logs, cursor, err := c.ChatGetLogs(ctx, c.chatCursor)
 	if err != nil {
 		return nil, err
 	}
-	c.chatCursor = *logOutput.Cursor
-	return logOutput.Logs, nil
+	c.chatCursor = cursor
+	return logs, nil
+}
+
+// Get the internal chat log cursor used by ChatGetRecentLogs, e.g. in order to persist it.
+func (c *Client) ChatCursor() string {
+	c.chatCursorLock.Lock()
+	defer c.chatCursorLock.Unlock()
+	return c.chatCursor
+}
+
+// Set the internal chat log cursor used by ChatGetRecentLogs, e.g. to resume from a persisted cursor.
+func (c *Client) SetChatCursor(cursor string) {
+	c.chatCursorLock.Lock()
+	defer c.chatCursorLock.Unlock()
+	c.chatCursor = cursor
 }
@@ -0,0 +1,160 @@
+package botsky
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// A mention of a handle in a text, resolved to the DID of the account.
+type mentionMatch struct {
+	Value string
+	Start int
+	End   int
+	Did   string
+}
+
+// Find all mentions (@handle) in the text whose handles can be resolved.
+func (c *Client) detectMentions(ctx context.Context, text string) []mentionMatch {
+	mentionRegex := `[^a-zA-Z0-9](@` + domainRegex + `)`
+	re := regexp.MustCompile(mentionRegex)
+	matches := re.FindAllStringSubmatchIndex(text, -1)
+
+	var mentionMatches []mentionMatch
+	for _, m := range matches {
+		start := m[2]
+		end := m[3]
+		value := text[start:end]
+		// cut off the @
+		handle := value[1:]
+		resolveOutput, err := atproto.IdentityResolveHandle(ctx, c.xrpcClient, handle)
+		if err != nil {
+			// cannot resolve handle => not a mention
+			continue
+		}
+		mentionMatches = append(mentionMatches, mentionMatch{
+			Value: handle,
+			Start: start,
+			End:   end,
+			Did:   resolveOutput.Did,
+		})
+	}
+	return mentionMatches
+}
+
+// Build the facets (mentions, links and optionally hashtags) of a text. Used for both posts and chat messages.
+func buildFacets(text string, mentionMatches []mentionMatch, inlineLinks []InlineLink, hashtags bool) ([]*bsky.RichtextFacet, error) {
+	// RichtextFacet Section
+	// https://docs.bsky.app/docs/advanced-guides/post-richtext
+
+	Facets := []*bsky.RichtextFacet{}
+
+	// mentions
+	for _, match := range mentionMatches {
+		facet := &bsky.RichtextFacet{}
+		features := []*bsky.RichtextFacet_Features_Elem{}
+		feature := &bsky.RichtextFacet_Features_Elem{
+			RichtextFacet_Mention: &bsky.RichtextFacet_Mention{
+				LexiconTypeID: facetTypeMention.String(),
+				Did:           match.Did,
+			},
+		}
+		features = append(features, feature)
+		facet.Features = features
+
+		index := &bsky.RichtextFacet_ByteSlice{
+			ByteStart: int64(match.Start),
+			ByteEnd:   int64(match.End),
+		}
+		facet.Index = index
+
+		Facets = append(Facets, facet)
+	}
+
+	// user-provided inline links
+	for _, link := range inlineLinks {
+		facet := &bsky.RichtextFacet{}
+		features := []*bsky.RichtextFacet_Features_Elem{}
+		feature := &bsky.RichtextFacet_Features_Elem{
+			RichtextFacet_Link: &bsky.RichtextFacet_Link{
+				LexiconTypeID: facetTypeLink.String(),
+				Uri:           link.Url,
+			},
+		}
+		features = append(features, feature)
+		facet.Features = features
+
+		ByteStart, ByteEnd, err := findSubstring(text, link.Text)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to find the substring: %v , %v", facetTypeLink, err)
+		}
+
+		index := &bsky.RichtextFacet_ByteSlice{
+			ByteStart: int64(ByteStart),
+			ByteEnd:   int64(ByteEnd),
+		}
+		facet.Index = index
+
+		Facets = append(Facets, facet)
+	}
+
+	// auto-detect inline links
+	urlRegex := `https?:\/\/` + domainRegex + `(\/(` + domainRegex + `)+)*\/?`
+	matches := findRegexMatches(text, urlRegex)
+	for _, match := range matches {
+		facet := &bsky.RichtextFacet{}
+		features := []*bsky.RichtextFacet_Features_Elem{}
+		feature := &bsky.RichtextFacet_Features_Elem{
+			RichtextFacet_Link: &bsky.RichtextFacet_Link{
+				LexiconTypeID: facetTypeLink.String(),
+				Uri:           match.Value,
+			},
+		}
+		features = append(features, feature)
+		facet.Features = features
+
+		index := &bsky.RichtextFacet_ByteSlice{
+			ByteStart: int64(match.Start),
+			ByteEnd:   int64(match.End),
+		}
+		facet.Index = index
+
+		Facets = append(Facets, facet)
+
+	}
+
+	// hashtags
+	if !hashtags {
+		return Facets, nil
+	}
+	hashtagRegex := `(?:^|\s)(#[^\d\s]\S*)`
+	matches = findRegexMatches(text, hashtagRegex)
+	for _, m := range matches {
+		facet := &bsky.RichtextFacet{}
+		features := []*bsky.RichtextFacet_Features_Elem{}
+		feature := &bsky.RichtextFacet_Features_Elem{}
+
+		feature = &bsky.RichtextFacet_Features_Elem{
+			RichtextFacet_Tag: &bsky.RichtextFacet_Tag{
+				LexiconTypeID: facetTypeTag.String(),
+				Tag:           stripHashtag(m.Value),
+			},
+		}
+
+		features = append(features, feature)
+		facet.Features = features
+
+		index := &bsky.RichtextFacet_ByteSlice{
+			ByteStart: int64(m.Start),
+			ByteEnd:   int64(m.End),
+		}
+		facet.Index = index
+
+		Facets = append(Facets, facet)
+	}
+
+	return Facets, nil
+}
@@ -0,0 +1,171 @@
+package botsky
+
+import (
+	"context"
+	"fmt"
+	"iter"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// Filters for author feeds.
+const (
+	AuthorFeedPostsWithReplies      = "posts_with_replies"
+	AuthorFeedPostsNoReplies        = "posts_no_replies"
+	AuthorFeedPostsWithMedia        = "posts_with_media"
+	AuthorFeedPostsAndAuthorThreads = "posts_and_author_threads"
+)
+
+// Options for reading an author feed.
+type AuthorFeedOptions struct {
+	Filter      string // one of the AuthorFeed* constants, defaults to AuthorFeedPostsWithReplies
+	IncludePins bool   // include the pinned post at the top of the feed
+}
+
+// A post in a feed, with the reason why it appears in the feed.
+type FeedItem struct {
+	*RichPost
+	RepostedBy  *bsky.ActorDefs_ProfileViewBasic // set if the post appears because it was reposted
+	RepostedAt  string
+	Pinned      bool   // the post is the pinned post of the author
+	FeedContext string // context provided by a feed generator, if any
+}
+
+// Iterate over the posts and reposts of an account, newest first.
+//
+// Pages are fetched lazily. Pass a Cursor to resume a previous iteration, or nil to start at the beginning.
+func (c *Client) IterAuthorFeed(ctx context.Context, handleOrDid string, opts AuthorFeedOptions, cursor *Cursor) iter.Seq2[*FeedItem, error] {
+	return paginate(ctx, cursor, func(ctx context.Context, cursor string) ([]*FeedItem, *string, error) {
+		output, err := bsky.FeedGetAuthorFeed(ctx, c.xrpcClient, handleOrDid, cursor, opts.Filter, opts.IncludePins, pageSize)
+		if err != nil {
+			return nil, nil, fmt.Errorf("IterAuthorFeed error (FeedGetAuthorFeed): %v", err)
+		}
+		items, err := c.newFeedItems(output.Feed)
+		if err != nil {
+			return nil, nil, fmt.Errorf("IterAuthorFeed error: %v", err)
+		}
+		return items, output.Cursor, nil
+	})
+}
+
+// Get the posts and reposts of an account, newest first.
+//
+// Set limit = -1 in order to get the whole feed.
+func (c *Client) GetAuthorFeed(ctx context.Context, handleOrDid string, opts AuthorFeedOptions, limit int) ([]*FeedItem, error) {
+	items, err := collect(c.IterAuthorFeed(ctx, handleOrDid, opts, nil), limit)
+	if err != nil {
+		return nil, fmt.Errorf("GetAuthorFeed error: %v", err)
+	}
+	return items, nil
+}
+
+// Iterate over the home timeline of the bot (posts of the accounts it follows), newest first.
+//
+// Pages are fetched lazily. Pass a Cursor to resume a previous iteration, or nil to start at the beginning.
+func (c *Client) IterTimeline(ctx context.Context, cursor *Cursor) iter.Seq2[*FeedItem, error] {
+	return paginate(ctx, cursor, func(ctx context.Context, cursor string) ([]*FeedItem, *string, error) {
+		output, err := bsky.FeedGetTimeline(ctx, c.xrpcClient, "", cursor, pageSize)
+		if err != nil {
+			return nil, nil, fmt.Errorf("IterTimeline error (FeedGetTimeline): %v", err)
+		}
+		items, err := c.newFeedItems(output.Feed)
+		if err != nil {
+			return nil, nil, fmt.Errorf("IterTimeline error: %v", err)
+		}
+		return items, output.Cursor, nil
+	})
+}
+
+// Get the home timeline of the bot, newest first.
+//
+// Set limit = -1 in order to get the whole timeline.
+func (c *Client) GetTimeline(ctx context.Context, limit int) ([]*FeedItem, error) {
+	items, err := collect(c.IterTimeline(ctx, nil), limit)
+	if err != nil {
+		return nil, fmt.Errorf("GetTimeline error: %v", err)
+	}
+	return items, nil
+}
+
+// Iterate over a custom feed, given by the uri of its feed generator record (at://did/app.bsky.feed.generator/rkey).
+//
+// Pages are fetched lazily. Pass a Cursor to resume a previous iteration, or nil to start at the beginning.
+func (c *Client) IterFeed(ctx context.Context, feedUri string, cursor *Cursor) iter.Seq2[*FeedItem, error] {
+	return paginate(ctx, cursor, func(ctx context.Context, cursor string) ([]*FeedItem, *string, error) {
+		output, err := bsky.FeedGetFeed(ctx, c.xrpcClient, cursor, feedUri, pageSize)
+		if err != nil {
+			return nil, nil, fmt.Errorf("IterFeed error (FeedGetFeed): %v", err)
+		}
+		items, err := c.newFeedItems(output.Feed)
+		if err != nil {
+			return nil, nil, fmt.Errorf("IterFeed error: %v", err)
+		}
+		return items, output.Cursor, nil
+	})
+}
+
+// Get the posts of a custom feed, given by the uri of its feed generator record.
+//
+// Set limit = -1 in order to get the whole feed.
+func (c *Client) GetFeed(ctx context.Context, feedUri string, limit int) ([]*FeedItem, error) {
+	items, err := collect(c.IterFeed(ctx, feedUri, nil), limit)
+	if err != nil {
+		return nil, fmt.Errorf("GetFeed error: %v", err)
+	}
+	return items, nil
+}
+
+// Iterate over the posts of the members of a list, given by the uri of the list record (at://did/app.bsky.graph.list/rkey).
+//
+// Pages are fetched lazily. Pass a Cursor to resume a previous iteration, or nil to start at the beginning.
+func (c *Client) IterListFeed(ctx context.Context, listUri string, cursor *Cursor) iter.Seq2[*FeedItem, error] {
+	return paginate(ctx, cursor, func(ctx context.Context, cursor string) ([]*FeedItem, *string, error) {
+		output, err := bsky.FeedGetListFeed(ctx, c.xrpcClient, cursor, pageSize, listUri)
+		if err != nil {
+			return nil, nil, fmt.Errorf("IterListFeed error (FeedGetListFeed): %v", err)
+		}
+		items, err := c.newFeedItems(output.Feed)
+		if err != nil {
+			return nil, nil, fmt.Errorf("IterListFeed error: %v", err)
+		}
+		return items, output.Cursor, nil
+	})
+}
+
+// Get the posts of the members of a list, given by the uri of the list record.
+//
+// Set limit = -1 in order to get the whole feed.
+func (c *Client) GetListFeed(ctx context.Context, listUri string, limit int) ([]*FeedItem, error) {
+	items, err := collect(c.IterListFeed(ctx, listUri, nil), limit)
+	if err != nil {
+		return nil, fmt.Errorf("GetListFeed error: %v", err)
+	}
+	return items, nil
+}
+
+// Build FeedItems from a page of a feed.
+func (c *Client) newFeedItems(views []*bsky.FeedDefs_FeedViewPost) ([]*FeedItem, error) {
+	items := make([]*FeedItem, 0, len(views))
+	for _, view := range views {
+		if view.Post == nil {
+			continue
+		}
+		post, err := c.newRichPost(view.Post)
+		if err != nil {
+			return nil, err
+		}
+		item := &FeedItem{RichPost: post}
+		if view.Reason != nil {
+			if repost := view.Reason.FeedDefs_ReasonRepost; repost != nil {
+				item.RepostedBy = repost.By
+				item.RepostedAt = repost.IndexedAt
+			}
+			item.Pinned = view.Reason.FeedDefs_ReasonPin != nil
+		}
+		if view.FeedContext != nil {
+			item.FeedContext = *view.FeedContext
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -0,0 +1,35 @@
+package botsky
+
+import (
+	"context"
+	"fmt"
+	"iter"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// Iterate over all accounts following the given account.
+//
+// Pages are fetched lazily. Pass a Cursor to resume a previous iteration, or nil to start at the beginning.
+func (c *Client) IterFollowers(ctx context.Context, handleOrDid string, cursor *Cursor) iter.Seq2[*bsky.ActorDefs_ProfileView, error] {
+	return paginate(ctx, cursor, func(ctx context.Context, cursor string) ([]*bsky.ActorDefs_ProfileView, *string, error) {
+		output, err := bsky.GraphGetFollowers(ctx, c.xrpcClient, handleOrDid, cursor, pageSize)
+		if err != nil {
+			return nil, nil, fmt.Errorf("IterFollowers error (GraphGetFollowers): %v", err)
+		}
+		return output.Followers, output.Cursor, nil
+	})
+}
+
+// Iterate over all accounts the given account follows.
+//
+// Pages are fetched lazily. Pass a Cursor to resume a previous iteration, or nil to start at the beginning.
+func (c *Client) IterFollows(ctx context.Context, handleOrDid string, cursor *Cursor) iter.Seq2[*bsky.ActorDefs_ProfileView, error] {
+	return paginate(ctx, cursor, func(ctx context.Context, cursor string) ([]*bsky.ActorDefs_ProfileView, *string, error) {
+		output, err := bsky.GraphGetFollows(ctx, c.xrpcClient, handleOrDid, cursor, pageSize)
+		if err != nil {
+			return nil, nil, fmt.Errorf("IterFollows error (GraphGetFollows): %v", err)
+		}
+		return output.Follows, output.Cursor, nil
+	})
+}
@@ -0,0 +1,116 @@
+package botsky
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/ipfs/go-cid"
+)
+
+// File extensions for the media types used by Bluesky, preferred over the (sometimes odd) system mime table.
+var mediaExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/heic": ".heic",
+	"video/mp4":  ".mp4",
+	"video/webm": ".webm",
+	"text/vtt":   ".vtt",
+}
+
+// Download all media (images, video, external link thumbnails) embedded in the post to the given directory.
+//
+// The original blobs are fetched from the PDS of the post author and verified against their CIDs.
+// Files are named after the blob CID, with an extension matching the mime type.
+// Media of quoted posts is not included.
+//
+// Returns the paths of the written files.
+func (p *RichPost) DownloadMedia(ctx context.Context, dir string) ([]string, error) {
+	if p.client == nil {
+		return nil, fmt.Errorf("DownloadMedia error: post was not loaded through a client")
+	}
+	blobs := embeddedBlobs(p.Embed)
+	if len(blobs) == 0 {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("DownloadMedia error (MkdirAll): %v", err)
+	}
+
+	paths := make([]string, 0, len(blobs))
+	for _, blob := range blobs {
+		// e.g. link embeds without a card image
+		if !cid.Cid(blob.Ref).Defined() {
+			continue
+		}
+		blobCid := cid.Cid(blob.Ref).String()
+		data, err := p.client.GetBlob(ctx, p.AuthorDid, blobCid)
+		if err != nil {
+			return paths, fmt.Errorf("DownloadMedia error (GetBlob): %v", err)
+		}
+
+		path := filepath.Join(dir, blobCid+mediaExtension(blob.MimeType))
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return paths, fmt.Errorf("DownloadMedia error (WriteFile): %v", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// Collect all blobs referenced by a post embed, including the media part of record-with-media embeds.
+func embeddedBlobs(embed *bsky.FeedPost_Embed) []*lexutil.LexBlob {
+	if embed == nil {
+		return nil
+	}
+	blobs := mediaBlobs(embed.EmbedImages, embed.EmbedVideo, embed.EmbedExternal)
+	if embed.EmbedRecordWithMedia != nil && embed.EmbedRecordWithMedia.Media != nil {
+		media := embed.EmbedRecordWithMedia.Media
+		blobs = append(blobs, mediaBlobs(media.EmbedImages, media.EmbedVideo, media.EmbedExternal)...)
+	}
+	return blobs
+}
+
+// Collect the blobs of the given (optional) media embeds.
+func mediaBlobs(images *bsky.EmbedImages, video *bsky.EmbedVideo, external *bsky.EmbedExternal) []*lexutil.LexBlob {
+	var blobs []*lexutil.LexBlob
+	if images != nil {
+		for _, img := range images.Images {
+			if img != nil && img.Image != nil {
+				blobs = append(blobs, img.Image)
+			}
+		}
+	}
+	if video != nil {
+		if video.Video != nil {
+			blobs = append(blobs, video.Video)
+		}
+		for _, caption := range video.Captions {
+			if caption != nil && caption.File != nil {
+				blobs = append(blobs, caption.File)
+			}
+		}
+	}
+	if external != nil && external.External != nil && external.External.Thumb != nil {
+		blobs = append(blobs, external.External.Thumb)
+	}
+	return blobs
+}
+
+// Get a file extension for the given mime type.
+func mediaExtension(mimeType string) string {
+	if ext, ok := mediaExtensions[mimeType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
@@ -0,0 +1,461 @@
+package botsky
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// Account migration from the bots current PDS to a new one (e.g. from bsky.social to a self-hosted PDS).
+//
+// Follows the steps described in https://github.com/bluesky-social/pds/blob/main/ACCOUNT_MIGRATION.md
+// Every step is recorded in the MigrationState once it completed, so an interrupted migration can be resumed
+// by passing the saved state to NewMigration again.
+
+// A single step of an account migration.
+type MigrationStep string
+
+const (
+	MigrationStepCreateAccount        MigrationStep = "createAccount"
+	MigrationStepImportRepo           MigrationStep = "importRepo"
+	MigrationStepMigrateBlobs         MigrationStep = "migrateBlobs"
+	MigrationStepMigratePreferences   MigrationStep = "migratePreferences"
+	MigrationStepRequestPlcSignature  MigrationStep = "requestPlcSignature"
+	MigrationStepSubmitPlcOperation   MigrationStep = "submitPlcOperation"
+	MigrationStepActivateAccount      MigrationStep = "activateAccount"
+	MigrationStepDeactivateOldAccount MigrationStep = "deactivateOldAccount"
+)
+
+// All migration steps, in the order they are run.
+var migrationSteps = []MigrationStep{
+	MigrationStepCreateAccount,
+	MigrationStepImportRepo,
+	MigrationStepMigrateBlobs,
+	MigrationStepMigratePreferences,
+	MigrationStepRequestPlcSignature,
+	MigrationStepSubmitPlcOperation,
+	MigrationStepActivateAccount,
+	MigrationStepDeactivateOldAccount,
+}
+
+// Returned by Migration.Run if the PLC operation token is needed to continue.
+//
+// The token is sent to the email address of the account by the old PDS during the requestPlcSignature step.
+// Set it through Migration.SetPlcToken and call Run again.
+var ErrPlcTokenRequired = errors.New("PLC operation token required, check the accounts email")
+
+// Options for an account migration.
+type MigrationOptions struct {
+	NewPds      string // url of the new PDS, e.g. https://pds.example.com
+	NewHandle   string // handle of the account on the new PDS, defaults to the current handle
+	Email       string // email of the account on the new PDS
+	Password    string // password of the account on the new PDS
+	InviteCode  string // invite code, if the new PDS requires one
+	PlcToken    string // PLC operation token, can also be set later through Migration.SetPlcToken
+	RepoCarPath string // optional, restore the repo from this CAR file (e.g. written by SyncExportRepo) instead of the old PDS
+	BlobDir     string // optional, directory with blobs named by their CID (e.g. from DownloadMedia), used before the old PDS
+
+	OnProgress func(MigrationState) // optional, called whenever the state changes. Use it to persist the state.
+}
+
+// Progress of a migration. Can be serialized (e.g. as JSON) to resume a migration later.
+type MigrationState struct {
+	Did        string          `json:"did"`
+	Completed  []MigrationStep `json:"completed"`
+	BlobCursor string          `json:"blobCursor,omitempty"` // position in the list of missing blobs on the new PDS
+}
+
+// A (resumable) migration of the bots account to a new PDS.
+type Migration struct {
+	client    *Client      // authenticated client for the old PDS
+	newClient *xrpc.Client // client for the new PDS, authenticated once the account exists
+	opts      MigrationOptions
+	State     MigrationState
+}
+
+// Prepare the migration of the bots account to a new PDS. The client must be authenticated with the old PDS.
+//
+// Pass the state of a previous, interrupted migration to resume it, or nil to start from the beginning.
+func (c *Client) NewMigration(opts MigrationOptions, state *MigrationState) (*Migration, error) {
+	if opts.NewPds == "" {
+		return nil, fmt.Errorf("NewMigration error: NewPds must be set")
+	}
+	if opts.Password == "" {
+		return nil, fmt.Errorf("NewMigration error: Password must be set")
+	}
+	if opts.NewHandle == "" {
+		opts.NewHandle = c.Handle
+	}
+
+	m := &Migration{
+		client: c,
+		newClient: &xrpc.Client{
+			Client: new(http.Client),
+			Host:   strings.TrimSuffix(opts.NewPds, "/"),
+		},
+		opts:  opts,
+		State: MigrationState{Did: c.Did},
+	}
+	if state != nil {
+		if state.Did != c.Did {
+			return nil, fmt.Errorf("NewMigration error: state belongs to %s, not %s", state.Did, c.Did)
+		}
+		m.State = *state
+	}
+	return m, nil
+}
+
+// Set the PLC operation token received by email, needed for the submitPlcOperation step.
+func (m *Migration) SetPlcToken(token string) {
+	m.opts.PlcToken = strings.TrimSpace(token)
+}
+
+// Check whether the given step has already been completed.
+func (m *Migration) IsCompleted(step MigrationStep) bool {
+	return slices.Contains(m.State.Completed, step)
+}
+
+// Run all remaining migration steps in order.
+//
+// Returns ErrPlcTokenRequired (check with errors.Is) when the PLC token is needed but not set yet.
+func (m *Migration) Run(ctx context.Context) error {
+	for _, step := range migrationSteps {
+		if err := m.RunStep(ctx, step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run a single migration step, unless it has been completed already.
+func (m *Migration) RunStep(ctx context.Context, step MigrationStep) error {
+	if m.IsCompleted(step) {
+		return nil
+	}
+
+	var err error
+	switch step {
+	case MigrationStepCreateAccount:
+		err = m.createAccount(ctx)
+	case MigrationStepImportRepo:
+		err = m.importRepo(ctx)
+	case MigrationStepMigrateBlobs:
+		err = m.migrateBlobs(ctx)
+	case MigrationStepMigratePreferences:
+		err = m.migratePreferences(ctx)
+	case MigrationStepRequestPlcSignature:
+		err = m.requestPlcSignature(ctx)
+	case MigrationStepSubmitPlcOperation:
+		err = m.submitPlcOperation(ctx)
+	case MigrationStepActivateAccount:
+		err = m.activateAccount(ctx)
+	case MigrationStepDeactivateOldAccount:
+		err = m.deactivateOldAccount(ctx)
+	default:
+		return fmt.Errorf("RunStep error: unknown migration step %s", step)
+	}
+	if err != nil {
+		return fmt.Errorf("Migration step %s failed: %w", step, err)
+	}
+
+	logger.Println("Migration step completed:", step)
+	m.State.Completed = append(m.State.Completed, step)
+	m.reportProgress()
+	return nil
+}
+
+func (m *Migration) reportProgress() {
+	if m.opts.OnProgress != nil {
+		m.opts.OnProgress(m.State)
+	}
+}
+
+// Make sure the client for the new PDS is authenticated, logging in if the account was created in an earlier run.
+//
+// The session is refreshed once the access JWT is about to expire, since copying blobs can take longer than its lifetime.
+func (m *Migration) ensureNewSession(ctx context.Context) error {
+	if m.newClient.Auth != nil {
+		if tRemaining, err := getJwtTimeRemaining(m.newClient.Auth.AccessJwt); err == nil && tRemaining > time.Minute {
+			return nil
+		}
+		if canRefreshSession(m.newClient) {
+			session, err := refreshXrpcSession(ctx, m.newClient)
+			if err == nil {
+				m.newClient.Auth = &xrpc.AuthInfo{
+					AccessJwt:  session.AccessJwt,
+					RefreshJwt: session.RefreshJwt,
+					Handle:     session.Handle,
+					Did:        session.Did,
+				}
+				return nil
+			}
+			logger.Println("ensureNewSession error (refreshXrpcSession):", err)
+		}
+	}
+
+	session, err := atproto.ServerCreateSession(ctx, m.newClient, &atproto.ServerCreateSession_Input{
+		Identifier: m.State.Did,
+		Password:   m.opts.Password,
+	})
+	if err != nil {
+		return fmt.Errorf("ensureNewSession error (ServerCreateSession): %v", err)
+	}
+	m.newClient.Auth = &xrpc.AuthInfo{
+		AccessJwt:  session.AccessJwt,
+		RefreshJwt: session.RefreshJwt,
+		Handle:     session.Handle,
+		Did:        session.Did,
+	}
+	return nil
+}
+
+// Get a client for the sync endpoints of the old PDS, resolved from the DID document.
+func (m *Migration) oldPdsClient(ctx context.Context) (*xrpc.Client, error) {
+	pdsClient, err := m.client.pdsClientFor(ctx, m.State.Did)
+	if err != nil {
+		return nil, fmt.Errorf("oldPdsClient error: %v", err)
+	}
+	return pdsClient, nil
+}
+
+// Create the (deactivated) account with the existing DID on the new PDS, authorized by a service auth token from the old PDS.
+func (m *Migration) createAccount(ctx context.Context) error {
+	server, err := atproto.ServerDescribeServer(ctx, m.newClient)
+	if err != nil {
+		return fmt.Errorf("createAccount error (ServerDescribeServer): %v", err)
+	}
+
+	serviceAuth, err := atproto.ServerGetServiceAuth(ctx, m.client.xrpcClient, server.Did, time.Now().Add(time.Minute).Unix(), "com.atproto.server.createAccount")
+	if err != nil {
+		return fmt.Errorf("createAccount error (ServerGetServiceAuth): %v", err)
+	}
+
+	input := &atproto.ServerCreateAccount_Input{
+		Did:      &m.State.Did,
+		Handle:   m.opts.NewHandle,
+		Password: &m.opts.Password,
+	}
+	if m.opts.Email != "" {
+		input.Email = &m.opts.Email
+	}
+	if m.opts.InviteCode != "" {
+		input.InviteCode = &m.opts.InviteCode
+	}
+
+	m.newClient.Auth = &xrpc.AuthInfo{AccessJwt: serviceAuth.Token}
+	account, err := atproto.ServerCreateAccount(ctx, m.newClient, input)
+	if err != nil {
+		m.newClient.Auth = nil
+		return fmt.Errorf("createAccount error (ServerCreateAccount): %v", err)
+	}
+	m.newClient.Auth = &xrpc.AuthInfo{
+		AccessJwt:  account.AccessJwt,
+		RefreshJwt: account.RefreshJwt,
+		Handle:     account.Handle,
+		Did:        account.Did,
+	}
+	return nil
+}
+
+// Import the repo into the new PDS, either from the old PDS or from a CAR backup.
+func (m *Migration) importRepo(ctx context.Context) error {
+	if err := m.ensureNewSession(ctx); err != nil {
+		return err
+	}
+
+	var carBytes []byte
+	if m.opts.RepoCarPath != "" {
+		data, err := os.ReadFile(m.opts.RepoCarPath)
+		if err != nil {
+			return fmt.Errorf("importRepo error (ReadFile): %v", err)
+		}
+		carBytes = data
+	} else {
+		oldPds, err := m.oldPdsClient(ctx)
+		if err != nil {
+			return fmt.Errorf("importRepo error: %v", err)
+		}
+		data, err := atproto.SyncGetRepo(ctx, oldPds, m.State.Did, "")
+		if err != nil {
+			return fmt.Errorf("importRepo error (SyncGetRepo): %v", err)
+		}
+		carBytes = data
+	}
+
+	if err := atproto.RepoImportRepo(ctx, m.newClient, bytes.NewReader(carBytes)); err != nil {
+		return fmt.Errorf("importRepo error (RepoImportRepo): %v", err)
+	}
+	return nil
+}
+
+// Upload all blobs referenced by the imported repo which the new PDS doesn't have yet.
+func (m *Migration) migrateBlobs(ctx context.Context) error {
+	if err := m.ensureNewSession(ctx); err != nil {
+		return err
+	}
+	oldPds, err := m.oldPdsClient(ctx)
+	if err != nil {
+		return fmt.Errorf("migrateBlobs error: %v", err)
+	}
+
+	for {
+		if err := m.ensureNewSession(ctx); err != nil {
+			return err
+		}
+		output, err := atproto.RepoListMissingBlobs(ctx, m.newClient, m.State.BlobCursor, 100)
+		if err != nil {
+			return fmt.Errorf("migrateBlobs error (RepoListMissingBlobs): %v", err)
+		}
+
+		for _, blob := range output.Blobs {
+			data, err := m.readBlob(ctx, oldPds, blob.Cid)
+			if err != nil {
+				return fmt.Errorf("migrateBlobs error (readBlob): %v", err)
+			}
+			if err := m.ensureNewSession(ctx); err != nil {
+				return err
+			}
+			if _, err := atproto.RepoUploadBlob(ctx, m.newClient, bytes.NewReader(data)); err != nil {
+				return fmt.Errorf("migrateBlobs error (RepoUploadBlob): %v", err)
+			}
+		}
+
+		if output.Cursor == nil || *output.Cursor == "" || *output.Cursor == m.State.BlobCursor {
+			return nil
+		}
+		m.State.BlobCursor = *output.Cursor
+		m.reportProgress()
+	}
+}
+
+// Get a blob from the blob directory (if configured), or from the old PDS. The data is checked against the CID, a
+// file in the blob directory that doesn't match it is ignored.
+func (m *Migration) readBlob(ctx context.Context, oldPds *xrpc.Client, blobCid string) ([]byte, error) {
+	if m.opts.BlobDir != "" {
+		matches, _ := filepath.Glob(filepath.Join(m.opts.BlobDir, blobCid+"*"))
+		if len(matches) > 0 {
+			data, err := os.ReadFile(matches[0])
+			if err != nil {
+				return nil, fmt.Errorf("readBlob error (ReadFile): %v", err)
+			}
+			err = verifyBlob(blobCid, data)
+			if err == nil {
+				return data, nil
+			}
+			logger.Println("Ignoring blob file", matches[0], err)
+		}
+	}
+
+	data, err := atproto.SyncGetBlob(ctx, oldPds, blobCid, m.State.Did)
+	if err != nil {
+		return nil, fmt.Errorf("readBlob error (SyncGetBlob): %v", err)
+	}
+	if err := verifyBlob(blobCid, data); err != nil {
+		return nil, fmt.Errorf("readBlob error: %v", err)
+	}
+	return data, nil
+}
+
+// Copy the Bluesky app preferences (muted words, feeds, etc.) to the new PDS.
+//
+// Preferences are passed through as raw JSON, so that preference types unknown to indigo are not lost.
+func (m *Migration) migratePreferences(ctx context.Context) error {
+	if err := m.ensureNewSession(ctx); err != nil {
+		return err
+	}
+
+	var preferences json.RawMessage
+	if err := m.client.xrpcClient.Do(ctx, xrpc.Query, "", "app.bsky.actor.getPreferences", nil, nil, &preferences); err != nil {
+		return fmt.Errorf("migratePreferences error (getPreferences): %v", err)
+	}
+	if err := m.newClient.Do(ctx, xrpc.Procedure, "application/json", "app.bsky.actor.putPreferences", nil, preferences, nil); err != nil {
+		return fmt.Errorf("migratePreferences error (putPreferences): %v", err)
+	}
+	return nil
+}
+
+// Ask the old PDS to send the PLC operation token to the accounts email.
+func (m *Migration) requestPlcSignature(ctx context.Context) error {
+	if !strings.HasPrefix(m.State.Did, "did:plc:") {
+		logger.Println("Not a did:plc, the DID document has to be updated manually:", m.State.Did)
+		return nil
+	}
+	if err := atproto.IdentityRequestPlcOperationSignature(ctx, m.client.xrpcClient); err != nil {
+		return fmt.Errorf("requestPlcSignature error (IdentityRequestPlcOperationSignature): %v", err)
+	}
+	return nil
+}
+
+// Let the old PDS sign a PLC operation pointing the DID to the new PDS, and submit it through the new PDS.
+//
+// The DID credentials are passed through as raw JSON, since indigo can't decode them as lexicon types.
+func (m *Migration) submitPlcOperation(ctx context.Context) error {
+	if !strings.HasPrefix(m.State.Did, "did:plc:") {
+		return nil
+	}
+	if m.opts.PlcToken == "" {
+		return ErrPlcTokenRequired
+	}
+	if err := m.ensureNewSession(ctx); err != nil {
+		return err
+	}
+
+	var credentials map[string]json.RawMessage
+	if err := m.newClient.Do(ctx, xrpc.Query, "", "com.atproto.identity.getRecommendedDidCredentials", nil, nil, &credentials); err != nil {
+		return fmt.Errorf("submitPlcOperation error (getRecommendedDidCredentials): %v", err)
+	}
+	credentials["token"], _ = json.Marshal(m.opts.PlcToken)
+
+	var signed struct {
+		Operation json.RawMessage `json:"operation"`
+	}
+	if err := m.client.xrpcClient.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.identity.signPlcOperation", nil, credentials, &signed); err != nil {
+		return fmt.Errorf("submitPlcOperation error (signPlcOperation): %v", err)
+	}
+	if err := m.newClient.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.identity.submitPlcOperation", nil, signed, nil); err != nil {
+		return fmt.Errorf("submitPlcOperation error (submitPlcOperation): %v", err)
+	}
+	return nil
+}
+
+// Activate the account on the new PDS.
+func (m *Migration) activateAccount(ctx context.Context) error {
+	if err := m.ensureNewSession(ctx); err != nil {
+		return err
+	}
+	if err := atproto.ServerActivateAccount(ctx, m.newClient); err != nil {
+		return fmt.Errorf("activateAccount error (ServerActivateAccount): %v", err)
+	}
+	return nil
+}
+
+// Deactivate the account on the old PDS.
+func (m *Migration) deactivateOldAccount(ctx context.Context) error {
+	if err := atproto.ServerDeactivateAccount(ctx, m.client.xrpcClient, &atproto.ServerDeactivateAccount_Input{}); err != nil {
+		return fmt.Errorf("deactivateOldAccount error (ServerDeactivateAccount): %v", err)
+	}
+	return nil
+}
+
+// Check the status of the migrated account on the new PDS, e.g. to verify that all blobs were imported.
+func (m *Migration) CheckNewAccountStatus(ctx context.Context) (*atproto.ServerCheckAccountStatus_Output, error) {
+	if err := m.ensureNewSession(ctx); err != nil {
+		return nil, err
+	}
+	status, err := atproto.ServerCheckAccountStatus(ctx, m.newClient)
+	if err != nil {
+		return nil, fmt.Errorf("CheckNewAccountStatus error (ServerCheckAccountStatus): %v", err)
+	}
+	return status, nil
+}
@@ -0,0 +1,456 @@
+package botsky
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ipfs/go-cid"
+)
+
+const testDid = "did:plc:testmigration"
+
+// Unsigned JWT expiring after the given duration. Only the expiry is read by the client.
+func testJwt(t *testing.T, expiresIn time.Duration) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": testDid,
+		"exp": time.Now().Add(expiresIn).Unix(),
+		"jti": time.Now().String(), // make every token unique
+	})
+	signed, err := token.SignedString([]byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func testBlobCid(t *testing.T, data []byte) string {
+	t.Helper()
+	c, err := cid.Prefix{Version: 1, Codec: cid.Raw, MhType: 0x12, MhLength: -1}.Sum(data) // sha2-256
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.String()
+}
+
+// Fake PDS recording the calls it received. Handlers are registered per NSID.
+type fakePds struct {
+	server *httptest.Server
+	mutex  sync.Mutex
+	calls  map[string]int
+}
+
+func newFakePds(t *testing.T, handlers map[string]http.HandlerFunc) *fakePds {
+	t.Helper()
+	pds := &fakePds{calls: make(map[string]int)}
+	pds.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nsid := strings.TrimPrefix(r.URL.Path, "/xrpc/")
+		pds.mutex.Lock()
+		pds.calls[nsid]++
+		pds.mutex.Unlock()
+		handler, ok := handlers[nsid]
+		if !ok {
+			writeXrpcError(w, http.StatusNotImplemented, "MethodNotImplemented")
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(pds.server.Close)
+	return pds
+}
+
+func (p *fakePds) callCount(nsid string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.calls[nsid]
+}
+
+func writeJson(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeXrpcError(w http.ResponseWriter, status int, name string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": name, "message": name})
+}
+
+// State of the fake old and new PDS of a migration.
+type migrationFixture struct {
+	old, new *fakePds
+
+	repoCar     []byte
+	blobs       map[string][]byte // cid -> data, all on the old PDS
+	preferences string
+
+	mutex              sync.Mutex
+	importedRepo       []byte
+	uploadedBlobs      [][]byte
+	putPreferences     string
+	submittedOperation string
+	failUploadOnce     int // fail the upload with this (1-based) number once, 0 for never
+	uploads            int
+	accessExpiresIn    time.Duration // lifetime of access JWTs issued by the new PDS
+}
+
+func newMigrationFixture(t *testing.T) *migrationFixture {
+	t.Helper()
+	f := &migrationFixture{
+		repoCar:         []byte("fake repo car"),
+		blobs:           make(map[string][]byte),
+		preferences:     `{"preferences":[{"$type":"app.bsky.actor.defs#adultContentPref","enabled":false},{"$type":"app.bsky.actor.defs#unknownFuturePref","value":42}]}`,
+		accessExpiresIn: time.Hour,
+	}
+	for _, data := range []string{"blob one", "blob two", "blob three"} {
+		f.blobs[testBlobCid(t, []byte(data))] = []byte(data)
+	}
+
+	f.old = newFakePds(t, map[string]http.HandlerFunc{
+		"com.atproto.server.getServiceAuth": func(w http.ResponseWriter, r *http.Request) {
+			writeJson(w, map[string]string{"token": "service-token"})
+		},
+		"com.atproto.sync.getRepo": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("did") != testDid {
+				writeXrpcError(w, http.StatusBadRequest, "RepoNotFound")
+				return
+			}
+			w.Header().Set("Content-Type", "application/vnd.ipld.car")
+			w.Write(f.repoCar)
+		},
+		"com.atproto.sync.getBlob": func(w http.ResponseWriter, r *http.Request) {
+			data, ok := f.blobs[r.URL.Query().Get("cid")]
+			if !ok {
+				writeXrpcError(w, http.StatusBadRequest, "BlobNotFound")
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(data)
+		},
+		"app.bsky.actor.getPreferences": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, f.preferences)
+		},
+		"com.atproto.identity.requestPlcOperationSignature": func(w http.ResponseWriter, r *http.Request) {},
+		"com.atproto.identity.signPlcOperation": func(w http.ResponseWriter, r *http.Request) {
+			var input map[string]any
+			json.NewDecoder(r.Body).Decode(&input)
+			if input["token"] != "plc-token" {
+				writeXrpcError(w, http.StatusBadRequest, "InvalidToken")
+				return
+			}
+			writeJson(w, map[string]any{"operation": map[string]any{"type": "plc_operation", "services": input["services"]}})
+		},
+		"com.atproto.server.deactivateAccount": func(w http.ResponseWriter, r *http.Request) {},
+	})
+
+	session := func() map[string]string {
+		f.mutex.Lock()
+		expiresIn := f.accessExpiresIn
+		f.mutex.Unlock()
+		return map[string]string{
+			"accessJwt":  testJwt(t, expiresIn),
+			"refreshJwt": testJwt(t, 24*time.Hour),
+			"handle":     "bot.new.test",
+			"did":        testDid,
+		}
+	}
+	f.new = newFakePds(t, map[string]http.HandlerFunc{
+		"com.atproto.server.describeServer": func(w http.ResponseWriter, r *http.Request) {
+			writeJson(w, map[string]any{"did": "did:web:new.test", "availableUserDomains": []string{".new.test"}})
+		},
+		"com.atproto.server.createAccount": func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer service-token" {
+				writeXrpcError(w, http.StatusUnauthorized, "AuthRequired")
+				return
+			}
+			writeJson(w, session())
+		},
+		"com.atproto.server.createSession": func(w http.ResponseWriter, r *http.Request) {
+			writeJson(w, session())
+		},
+		"com.atproto.server.refreshSession": func(w http.ResponseWriter, r *http.Request) {
+			writeJson(w, session())
+		},
+		"com.atproto.repo.importRepo": func(w http.ResponseWriter, r *http.Request) {
+			data, _ := io.ReadAll(r.Body)
+			f.mutex.Lock()
+			f.importedRepo = data
+			f.mutex.Unlock()
+		},
+		"com.atproto.repo.listMissingBlobs": func(w http.ResponseWriter, r *http.Request) {
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+			// one blob per page, the cursor is the cid of the last blob
+			var missing []string
+			for blobCid, data := range f.blobs {
+				if !slices.ContainsFunc(f.uploadedBlobs, func(uploaded []byte) bool { return string(uploaded) == string(data) }) {
+					missing = append(missing, blobCid)
+				}
+			}
+			slices.Sort(missing)
+			cursor := r.URL.Query().Get("cursor")
+			missing = slices.DeleteFunc(missing, func(blobCid string) bool { return blobCid <= cursor })
+			if len(missing) == 0 {
+				writeJson(w, map[string]any{"blobs": []any{}})
+				return
+			}
+			writeJson(w, map[string]any{
+				"cursor": missing[0],
+				"blobs":  []any{map[string]string{"cid": missing[0], "recordUri": "at://" + testDid + "/app.bsky.feed.post/1"}},
+			})
+		},
+		"com.atproto.repo.uploadBlob": func(w http.ResponseWriter, r *http.Request) {
+			data, _ := io.ReadAll(r.Body)
+			f.mutex.Lock()
+			defer f.mutex.Unlock()
+			f.uploads++
+			if f.uploads == f.failUploadOnce {
+				writeXrpcError(w, http.StatusInternalServerError, "InternalServerError")
+				return
+			}
+			f.uploadedBlobs = append(f.uploadedBlobs, data)
+			writeJson(w, map[string]any{"blob": map[string]any{
+				"$type":    "blob",
+				"ref":      map[string]string{"$link": testBlobCid(t, data)},
+				"mimeType": "application/octet-stream",
+				"size":     len(data),
+			}})
+		},
+		"app.bsky.actor.putPreferences": func(w http.ResponseWriter, r *http.Request) {
+			data, _ := io.ReadAll(r.Body)
+			f.mutex.Lock()
+			f.putPreferences = string(data)
+			f.mutex.Unlock()
+		},
+		"com.atproto.identity.getRecommendedDidCredentials": func(w http.ResponseWriter, r *http.Request) {
+			writeJson(w, map[string]any{"services": map[string]any{"atproto_pds": map[string]string{"type": "AtprotoPersonalDataServer", "endpoint": "https://new.test"}}})
+		},
+		"com.atproto.identity.submitPlcOperation": func(w http.ResponseWriter, r *http.Request) {
+			data, _ := io.ReadAll(r.Body)
+			f.mutex.Lock()
+			f.submittedOperation = string(data)
+			f.mutex.Unlock()
+		},
+		"com.atproto.server.activateAccount": func(w http.ResponseWriter, r *http.Request) {},
+	})
+	return f
+}
+
+// Client authenticated with the old PDS, resolving its DID to the old PDS.
+func (f *migrationFixture) client(t *testing.T) *Client {
+	t.Helper()
+	directory := identity.NewMockDirectory()
+	directory.Insert(identity.Identity{
+		DID:      syntax.DID(testDid),
+		Handle:   syntax.Handle("bot.old.test"),
+		Services: map[string]identity.ServiceEndpoint{"atproto_pds": {Type: "AtprotoPersonalDataServer", URL: f.old.server.URL}},
+	})
+	return &Client{
+		xrpcClient: &xrpc.Client{
+			Client: f.old.server.Client(),
+			Host:   f.old.server.URL,
+			Auth:   &xrpc.AuthInfo{AccessJwt: testJwt(t, time.Hour), Did: testDid},
+		},
+		Handle:    "bot.old.test",
+		Did:       testDid,
+		directory: &directory,
+	}
+}
+
+func (f *migrationFixture) options() MigrationOptions {
+	return MigrationOptions{
+		NewPds:   f.new.server.URL + "/",
+		Email:    "bot@example.com",
+		Password: "hunter2",
+	}
+}
+
+func TestMigrationFull(t *testing.T) {
+	ctx := context.Background()
+	f := newMigrationFixture(t)
+	// the session on the new PDS expires during the migration and has to be refreshed
+	f.accessExpiresIn = 30 * time.Second
+
+	var saved []MigrationState
+	opts := f.options()
+	opts.OnProgress = func(state MigrationState) { saved = append(saved, state) }
+	m, err := f.client(t).NewMigration(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Run(ctx)
+	if !errors.Is(err, ErrPlcTokenRequired) {
+		t.Fatalf("expected ErrPlcTokenRequired, got %v", err)
+	}
+	if !m.IsCompleted(MigrationStepRequestPlcSignature) || m.IsCompleted(MigrationStepSubmitPlcOperation) {
+		t.Fatalf("unexpected completed steps: %v", m.State.Completed)
+	}
+
+	f.mutex.Lock()
+	f.accessExpiresIn = time.Hour
+	f.mutex.Unlock()
+	m.SetPlcToken(" plc-token\n")
+	if err := m.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(m.State.Completed, migrationSteps) {
+		t.Fatalf("completed %v, want %v", m.State.Completed, migrationSteps)
+	}
+	if len(saved) == 0 || !slices.Equal(saved[len(saved)-1].Completed, migrationSteps) {
+		t.Fatal("progress was not reported")
+	}
+	if string(f.importedRepo) != string(f.repoCar) {
+		t.Fatalf("imported repo %q", f.importedRepo)
+	}
+	if len(f.uploadedBlobs) != len(f.blobs) {
+		t.Fatalf("uploaded %d blobs, want %d", len(f.uploadedBlobs), len(f.blobs))
+	}
+	if !strings.Contains(f.putPreferences, "unknownFuturePref") {
+		t.Fatalf("unknown preferences were lost: %s", f.putPreferences)
+	}
+	if !strings.Contains(f.submittedOperation, "plc_operation") || !strings.Contains(f.submittedOperation, "https://new.test") {
+		t.Fatalf("submitted operation %s", f.submittedOperation)
+	}
+	for _, nsid := range []string{"com.atproto.server.createAccount", "com.atproto.repo.importRepo", "com.atproto.server.activateAccount"} {
+		if n := f.new.callCount(nsid); n != 1 {
+			t.Fatalf("%s called %d times", nsid, n)
+		}
+	}
+	if n := f.old.callCount("com.atproto.server.deactivateAccount"); n != 1 {
+		t.Fatalf("deactivateAccount called %d times", n)
+	}
+	if f.new.callCount("com.atproto.server.refreshSession") == 0 {
+		t.Fatal("the session on the new PDS was not refreshed")
+	}
+}
+
+func TestMigrationResumeAfterFailure(t *testing.T) {
+	ctx := context.Background()
+	f := newMigrationFixture(t)
+	f.failUploadOnce = 2
+
+	var saved MigrationState
+	opts := f.options()
+	opts.OnProgress = func(state MigrationState) { saved = state }
+	m, err := f.client(t).NewMigration(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Run(ctx)
+	if err == nil || !strings.Contains(err.Error(), string(MigrationStepMigrateBlobs)) {
+		t.Fatalf("expected the blob migration to fail, got %v", err)
+	}
+	if !slices.Equal(saved.Completed, []MigrationStep{MigrationStepCreateAccount, MigrationStepImportRepo}) {
+		t.Fatalf("completed %v", saved.Completed)
+	}
+	if saved.BlobCursor == "" {
+		t.Fatal("blob progress was not saved")
+	}
+
+	// resume with a fresh migration from the saved state, as after a restart
+	saved.Completed = slices.Clone(saved.Completed)
+	opts.PlcToken = "plc-token"
+	resumed, err := f.client(t).NewMigration(opts, &saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(resumed.State.Completed, migrationSteps) {
+		t.Fatalf("completed %v", resumed.State.Completed)
+	}
+	if len(f.uploadedBlobs) != len(f.blobs) {
+		t.Fatalf("uploaded %d blobs, want %d", len(f.uploadedBlobs), len(f.blobs))
+	}
+	// completed steps are not repeated, the resumed migration logs in instead
+	if n := f.new.callCount("com.atproto.server.createAccount"); n != 1 {
+		t.Fatalf("createAccount called %d times", n)
+	}
+	if n := f.new.callCount("com.atproto.repo.importRepo"); n != 1 {
+		t.Fatalf("importRepo called %d times", n)
+	}
+	if n := f.new.callCount("com.atproto.server.createSession"); n != 1 {
+		t.Fatalf("createSession called %d times", n)
+	}
+}
+
+func TestMigrationOldPdsNotResolvable(t *testing.T) {
+	ctx := context.Background()
+	f := newMigrationFixture(t)
+	client := f.client(t)
+	directory := identity.NewMockDirectory()
+	client.directory = &directory
+
+	m, err := client.NewMigration(f.options(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(ctx); err == nil {
+		t.Fatal("expected an error")
+	}
+	if m.IsCompleted(MigrationStepImportRepo) {
+		t.Fatal("repo imported without resolving the old PDS")
+	}
+	if n := f.old.callCount("com.atproto.sync.getRepo"); n != 0 {
+		t.Fatalf("getRepo called %d times", n)
+	}
+}
+
+func TestMigrationBlobDirIsVerified(t *testing.T) {
+	ctx := context.Background()
+	f := newMigrationFixture(t)
+	dir := t.TempDir()
+	cids := slices.Sorted(maps.Keys(f.blobs))
+	// one intact local copy, one corrupted one, the third blob is only on the old PDS
+	if err := os.WriteFile(filepath.Join(dir, cids[0]+".jpg"), f.blobs[cids[0]], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, cids[1]+".jpg"), []byte("corrupted"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := f.options()
+	opts.BlobDir = dir
+	opts.PlcToken = "plc-token"
+	m, err := f.client(t).NewMigration(opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.uploadedBlobs) != len(f.blobs) {
+		t.Fatalf("uploaded %d blobs, want %d", len(f.uploadedBlobs), len(f.blobs))
+	}
+	for _, data := range f.uploadedBlobs {
+		if string(data) == "corrupted" {
+			t.Fatal("uploaded the corrupted blob file")
+		}
+	}
+	if n := f.old.callCount("com.atproto.sync.getBlob"); n != 2 {
+		t.Fatalf("getBlob called %d times, want 2", n)
+	}
+}
@@ -3,31 +3,135 @@ package botsky
 import (
 	"context"
 	"fmt"
+	"iter"
 	"time"
 
 	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
 )
 
-// TODO: chekc that all functions with cursors that get lists/collections have the abilitiy to iterate and get more
+// Notification reasons, as used by the server in NotificationListNotifications_Notification.Reason.
+const (
+	NotifReasonMention           = "mention"
+	NotifReasonReply             = "reply"
+	NotifReasonQuote             = "quote"
+	NotifReasonFollow            = "follow"
+	NotifReasonLike              = "like"
+	NotifReasonRepost            = "repost"
+	NotifReasonStarterpackJoined = "starterpack-joined"
+)
+
+// Options for fetching notifications. The zero value fetches all notifications.
+type NotifOptions struct {
+	Reasons    []string  // only fetch notifications with these reasons (filtered server-side), e.g. NotifReasonMention
+	Priority   bool      // only fetch priority notifications (i.e. from accounts the bot follows)
+	UnreadOnly bool      // stop at the first notification that was already marked as seen
+	Since      time.Time // stop at the first notification indexed at or before this time
+}
+
+// A notification with its record already decoded.
+type Notification struct {
+	Uri           string // uri of the record that caused the notification (e.g. the mentioning post or the like)
+	Cid           string
+	Reason        string // one of the NotifReason* constants
+	ReasonSubject string // uri of the bots record the notification is about (e.g. the liked post), if any
+	Author        *bsky.ActorDefs_ProfileView
+	IndexedAt     time.Time
+	IsRead        bool
+	Post          *bsky.FeedPost // the post for mentions, replies and quotes, otherwise nil
+	Subject       string         // uri of the liked/reposted record, or DID of the followed account, otherwise ReasonSubject
+	Record        *lexutil.LexiconTypeDecoder
+}
+
+// Iterate over the notifications of the bot, newest first (doesn't include DMs!).
+//
+// Pages are fetched lazily until the boundary given by the options is reached.
+// Pass a Cursor to resume a previous iteration, or nil to start at the beginning.
+func (c *Client) IterNotifications(ctx context.Context, opts NotifOptions, cursor *Cursor) iter.Seq2[*Notification, error] {
+	views := paginate(ctx, cursor, func(ctx context.Context, cursor string) ([]*bsky.NotificationListNotifications_Notification, *string, error) {
+		output, err := bsky.NotificationListNotifications(ctx, c.xrpcClient, cursor, pageSize, opts.Priority, opts.Reasons, "")
+		if err != nil {
+			return nil, nil, fmt.Errorf("IterNotifications error (NotificationListNotifications): %v", err)
+		}
+		return output.Notifications, output.Cursor, nil
+	})
+
+	return func(yield func(*Notification, error) bool) {
+		for view, err := range views {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			notif, err := newNotification(view)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			// notifications are sorted by indexedAt, so everything after the boundary is older
+			if opts.UnreadOnly && notif.IsRead {
+				return
+			}
+			if !opts.Since.IsZero() && !notif.IndexedAt.After(opts.Since) {
+				return
+			}
+			if !yield(notif, nil) {
+				return
+			}
+		}
+	}
+}
 
 // Get the most recent notifications (doesn't include DMs!).
 //
-// Currently cannot get more than 100 notifications at once.
-func (c *Client) NotifGetNotifications(ctx context.Context, limit int64) ([]*bsky.NotificationListNotifications_Notification, error) {
-	limit = min(100, limit)
-	limit = max(1, limit)
-	limit = 10
-	priority := false
-	reasons := []string{}
-	output, err := bsky.NotificationListNotifications(ctx, c.xrpcClient, "", limit, priority, reasons, "")
+// Set limit = -1 in order to get all notifications (up to the boundary given by the options).
+func (c *Client) NotifGetNotifications(ctx context.Context, limit int64, opts NotifOptions) ([]*Notification, error) {
+	notifications, err := collect(c.IterNotifications(ctx, opts, nil), int(limit))
 	if err != nil {
 		return nil, fmt.Errorf("Error when calling ListNotifications: %v", err)
 	}
+	return notifications, nil
+}
+
+// Convert a notification view into a Notification, decoding its record.
+func newNotification(view *bsky.NotificationListNotifications_Notification) (*Notification, error) {
+	indexedAt, err := syntax.ParseDatetimeLenient(view.IndexedAt)
+	if err != nil {
+		return nil, fmt.Errorf("newNotification error (ParseDatetimeLenient): %v", err)
+	}
 
-	// TODO: iterate over remaining notifications with cursor
-	// (low prio, unlikely that there will be 100+ notifications at a time)
+	notif := &Notification{
+		Uri:       view.Uri,
+		Cid:       view.Cid,
+		Reason:    view.Reason,
+		Author:    view.Author,
+		IndexedAt: indexedAt.Time(),
+		IsRead:    view.IsRead,
+		Record:    view.Record,
+	}
+	if view.ReasonSubject != nil {
+		notif.ReasonSubject = *view.ReasonSubject
+	}
+	notif.Subject = notif.ReasonSubject
 
-	return output.Notifications, nil
+	if view.Record == nil {
+		return notif, nil
+	}
+	switch record := view.Record.Val.(type) {
+	case *bsky.FeedPost:
+		notif.Post = record
+	case *bsky.FeedLike:
+		if record.Subject != nil {
+			notif.Subject = record.Subject.Uri
+		}
+	case *bsky.FeedRepost:
+		if record.Subject != nil {
+			notif.Subject = record.Subject.Uri
+		}
+	case *bsky.GraphFollow:
+		notif.Subject = record.Subject
+	}
+	return notif, nil
 }
 
 // Get the number of unread notifications.
@@ -41,10 +145,13 @@ func (c *Client) NotifGetUnreadCount(ctx context.Context) (int64, error) {
 	return output.Count, nil
 }
 
-// Update all unseen notifications to seen.
-func (c *Client) NotifUpdateSeen(ctx context.Context) error {
+// Mark all notifications indexed at or before seenAt as seen.
+//
+// Use time.Now() to mark all notifications as seen. Prefer the IndexedAt of the newest handled notification though,
+// so notifications that arrived in the meantime aren't marked as seen without having been handled.
+func (c *Client) NotifUpdateSeen(ctx context.Context, seenAt time.Time) error {
 	updateSeenInput := bsky.NotificationUpdateSeen_Input{
-		SeenAt: time.Now().UTC().Format(time.RFC3339),
+		SeenAt: seenAt.UTC().Format(time.RFC3339Nano),
 	}
 	return bsky.NotificationUpdateSeen(ctx, c.xrpcClient, &updateSeenInput)
 }
@@ -0,0 +1,82 @@
+package botsky
+
+import (
+	"context"
+	"iter"
+)
+
+// Page size used when iterating over paginated endpoints (the maximum most endpoints allow).
+const pageSize = 100
+
+// Position in a paginated listing. The zero value starts at the beginning.
+//
+// Iterators update the cursor once a page has been fully consumed, so it always points to the first page that has not
+// been fully handled yet. Save cursor.Value and pass it to a later iteration in order to resume from there.
+// Ranging over the same iterator again starts over at the position the cursor had when the iterator was created.
+type Cursor struct {
+	Value string
+}
+
+// Lazily iterate over the items of a cursor-paginated endpoint.
+//
+// fetchPage is called with the current cursor and returns the items of the page and the cursor of the next one.
+// Iteration stops after the last page, on the first error, or when the context is cancelled.
+// If cursor is nil, iteration starts at the beginning.
+func paginate[T any](ctx context.Context, cursor *Cursor, fetchPage func(ctx context.Context, cursor string) ([]T, *string, error)) iter.Seq2[T, error] {
+	var start Cursor
+	if cursor != nil {
+		start = *cursor
+	}
+	return func(yield func(T, error) bool) {
+		var zero T
+		// every iteration starts at the initial position, so the iterator can be restarted
+		pos := start
+
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+				return
+			}
+
+			items, next, err := fetchPage(ctx, pos.Value)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			// stop if there are no more pages (or the server keeps returning the same cursor)
+			if len(items) == 0 || next == nil || *next == "" || *next == pos.Value {
+				return
+			}
+			pos.Value = *next
+			if cursor != nil {
+				cursor.Value = pos.Value
+			}
+		}
+	}
+}
+
+// Collect up to limit items from the iterator into a slice.
+//
+// Set limit = -1 in order to collect all items.
+func collect[T any](seq iter.Seq2[T, error], limit int) ([]T, error) {
+	var items []T
+	if limit == 0 {
+		return items, nil
+	}
+	for item, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if limit != -1 && len(items) >= limit {
+			break
+		}
+	}
+	return items, nil
+}
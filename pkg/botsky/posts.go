@@ -8,7 +8,6 @@ import (
 	"context"
 	"fmt"
 	"net/url"
-	"regexp"
 	"time"
 
 	"github.com/bluesky-social/indigo/api/atproto"
@@ -286,40 +285,7 @@ func (c *Client) Post(ctx context.Context, pb *PostBuilder) (string, string, err
 		}
 	}
 
-	// parse mentions
-	mentionRegex := `[^a-zA-Z0-9](@` + domainRegex + `)`
-	re := regexp.MustCompile(mentionRegex)
-	matches := re.FindAllStringSubmatchIndex(pb.Text, -1)
-
-	var mentionMatches []struct {
-		Value string
-		Start int
-		End   int
-		Did   string
-	}
-	for _, m := range matches {
-		start := m[2]
-		end := m[3]
-		value := pb.Text[start:end]
-		// cut off the @
-		handle := value[1:]
-		resolveOutput, err := atproto.IdentityResolveHandle(ctx, c.xrpcClient, handle)
-		if err != nil {
-			// cannot resolve handle => not a mention
-			continue
-		}
-		mentionMatches = append(mentionMatches, struct {
-			Value string
-			Start int
-			End   int
-			Did   string
-		}{
-			Value: handle,
-			Start: start,
-			End:   end,
-			Did:   resolveOutput.Did,
-		})
-	}
+	mentionMatches := c.detectMentions(ctx, pb.Text)
 
 	// Build post
 	post, err := buildPost(pb, embed, replyRef, mentionMatches)
@@ -332,12 +298,7 @@ func (c *Client) Post(ctx context.Context, pb *PostBuilder) (string, string, err
 }
 
 // Build the post
-func buildPost(pb *PostBuilder, embed embed, replyRef replyReference, mentionMatches []struct {
-	Value string
-	Start int
-	End   int
-	Did   string
-}) (bsky.FeedPost, error) {
+func buildPost(pb *PostBuilder, embed embed, replyRef replyReference, mentionMatches []mentionMatch) (bsky.FeedPost, error) {
 	post := bsky.FeedPost{Langs: pb.Languages}
 
 	post.Text = pb.Text
@@ -345,113 +306,11 @@ func buildPost(pb *PostBuilder, embed embed, replyRef replyReference, mentionMat
 	post.CreatedAt = time.Now().Format(time.RFC3339)
 	post.Tags = pb.AdditionalTags
 
-	// RichtextFacet Section
-	// https://docs.bsky.app/docs/advanced-guides/post-richtext
-
-	Facets := []*bsky.RichtextFacet{}
-
-	// mentions
-	for _, match := range mentionMatches {
-		facet := &bsky.RichtextFacet{}
-		features := []*bsky.RichtextFacet_Features_Elem{}
-		feature := &bsky.RichtextFacet_Features_Elem{
-			RichtextFacet_Mention: &bsky.RichtextFacet_Mention{
-				LexiconTypeID: facetTypeMention.String(),
-				Did:           match.Did,
-			},
-		}
-		features = append(features, feature)
-		facet.Features = features
-
-		index := &bsky.RichtextFacet_ByteSlice{
-			ByteStart: int64(match.Start),
-			ByteEnd:   int64(match.End),
-		}
-		facet.Index = index
-
-		Facets = append(Facets, facet)
-	}
-
-	// user-provided inline links
-	for _, link := range pb.InlineLinks {
-		facet := &bsky.RichtextFacet{}
-		features := []*bsky.RichtextFacet_Features_Elem{}
-		feature := &bsky.RichtextFacet_Features_Elem{
-			RichtextFacet_Link: &bsky.RichtextFacet_Link{
-				LexiconTypeID: facetTypeLink.String(),
-				Uri:           link.Url,
-			},
-		}
-		features = append(features, feature)
-		facet.Features = features
-
-		ByteStart, ByteEnd, err := findSubstring(post.Text, link.Text)
-		if err != nil {
-			return post, fmt.Errorf("Unable to find the substring: %v , %v", facetTypeLink, err)
-		}
-
-		index := &bsky.RichtextFacet_ByteSlice{
-			ByteStart: int64(ByteStart),
-			ByteEnd:   int64(ByteEnd),
-		}
-		facet.Index = index
-
-		Facets = append(Facets, facet)
-	}
-
-	// auto-detect inline links
-	urlRegex := `https?:\/\/` + domainRegex + `(\/(` + domainRegex + `)+)*\/?`
-	matches := findRegexMatches(pb.Text, urlRegex)
-	for _, match := range matches {
-		facet := &bsky.RichtextFacet{}
-		features := []*bsky.RichtextFacet_Features_Elem{}
-		feature := &bsky.RichtextFacet_Features_Elem{
-			RichtextFacet_Link: &bsky.RichtextFacet_Link{
-				LexiconTypeID: facetTypeLink.String(),
-				Uri:           match.Value,
-			},
-		}
-		features = append(features, feature)
-		facet.Features = features
-
-		index := &bsky.RichtextFacet_ByteSlice{
-			ByteStart: int64(match.Start),
-			ByteEnd:   int64(match.End),
-		}
-		facet.Index = index
-
-		Facets = append(Facets, facet)
-
-	}
-
-	// hashtags
-	hashtagRegex := `(?:^|\s)(#[^\d\s]\S*)`
-	matches = findRegexMatches(post.Text, hashtagRegex)
-	for _, m := range matches {
-		facet := &bsky.RichtextFacet{}
-		features := []*bsky.RichtextFacet_Features_Elem{}
-		feature := &bsky.RichtextFacet_Features_Elem{}
-
-		feature = &bsky.RichtextFacet_Features_Elem{
-			RichtextFacet_Tag: &bsky.RichtextFacet_Tag{
-				LexiconTypeID: facetTypeTag.String(),
-				Tag:           stripHashtag(m.Value),
-			},
-		}
-
-		features = append(features, feature)
-		facet.Features = features
-
-		index := &bsky.RichtextFacet_ByteSlice{
-			ByteStart: int64(m.Start),
-			ByteEnd:   int64(m.End),
-		}
-		facet.Index = index
-
-		Facets = append(Facets, facet)
+	facets, err := buildFacets(post.Text, mentionMatches, pb.InlineLinks, true)
+	if err != nil {
+		return post, err
 	}
-
-	post.Facets = Facets
+	post.Facets = facets
 
 	var FeedPost_Embed bsky.FeedPost_Embed
 	embedFlag := true
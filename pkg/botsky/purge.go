@@ -0,0 +1,254 @@
+package botsky
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	util "github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// Collections considered by RepoPurge if PurgeOptions.Collections is empty.
+var defaultPurgeCollections = []string{
+	"app.bsky.feed.post",
+	"app.bsky.feed.like",
+	"app.bsky.feed.repost",
+	"app.bsky.graph.follow",
+}
+
+// Options for RepoPurge. The zero value matches every record in the default collections (posts, likes, reposts, follows).
+//
+// TextRegex and EngagementBelow only apply to posts. If either of them is set, records of other collections never match.
+type PurgeOptions struct {
+	Collections     []string            // collections to clean up, defaults to posts, likes, reposts and follows
+	OlderThan       time.Duration       // only match records created longer than this ago, 0 disables the filter
+	TextRegex       *regexp.Regexp      // only match posts whose text matches the expression
+	EngagementBelow int64               // only match posts with fewer likes + reposts + replies + quotes than this, 0 disables the filter
+	KeepPinned      bool                // never match the post pinned to the bots profile
+	DryRun          bool                // only report the matching records, don't delete anything
+	BatchSize       int                 // number of deletions per request, defaults to 50
+	BatchDelay      time.Duration       // pause between two delete requests, defaults to 1s
+	OnProgress      func(PurgeProgress) // optional, called after every deleted batch
+}
+
+// A record matched by RepoPurge.
+type PurgeCandidate struct {
+	Uri        string
+	Collection string
+	Rkey       string
+	CreatedAt  time.Time
+}
+
+// Progress of a running RepoPurge.
+type PurgeProgress struct {
+	Deleted int // number of records deleted so far
+	Total   int // number of records that will be deleted in total
+}
+
+// Summary of a RepoPurge run.
+type PurgeReport struct {
+	Matched []PurgeCandidate // all records matching the filters
+	Deleted int              // number of records actually deleted (always 0 for dry runs)
+	DryRun  bool
+}
+
+// Delete posts, likes, reposts and follows from the bots repo, filtered by age, text, engagement, or pinned status.
+//
+// Deletions are submitted in small batches with a pause in between, to stay friendly to rate limits.
+// Set opts.DryRun to only get a report of what would be deleted.
+func (c *Client) RepoPurge(ctx context.Context, opts PurgeOptions) (PurgeReport, error) {
+	report := PurgeReport{DryRun: opts.DryRun}
+
+	collections := opts.Collections
+	if len(collections) == 0 {
+		collections = defaultPurgeCollections
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = 50
+	}
+	batchDelay := opts.BatchDelay
+	if batchDelay <= 0 {
+		batchDelay = time.Second
+	}
+	postFiltersSet := opts.TextRegex != nil || opts.EngagementBelow > 0
+
+	var pinnedUri string
+	if opts.KeepPinned {
+		uri, err := c.getPinnedPostUri(ctx)
+		if err != nil {
+			return report, fmt.Errorf("RepoPurge error (getPinnedPostUri): %v", err)
+		}
+		pinnedUri = uri
+	}
+
+	for _, collection := range collections {
+		isPost := collection == "app.bsky.feed.post"
+		if postFiltersSet && !isPost {
+			continue
+		}
+
+		// posts that passed the other filters, waiting for their engagement to be checked
+		var pending []PurgeCandidate
+		for record, err := range c.IterRecords(ctx, c.Did, collection, nil) {
+			if err != nil {
+				return report, fmt.Errorf("RepoPurge error (IterRecords): %v", err)
+			}
+			createdAt, text := purgeRecordInfo(record)
+
+			if opts.OlderThan > 0 && (createdAt.IsZero() || time.Since(createdAt) < opts.OlderThan) {
+				continue
+			}
+			if isPost && pinnedUri != "" && record.Uri == pinnedUri {
+				continue
+			}
+			if opts.TextRegex != nil && !opts.TextRegex.MatchString(text) {
+				continue
+			}
+
+			parsedUri, err := util.ParseAtUri(record.Uri)
+			if err != nil {
+				return report, fmt.Errorf("RepoPurge error (ParseAtUri): %v", err)
+			}
+			candidate := PurgeCandidate{
+				Uri:        record.Uri,
+				Collection: collection,
+				Rkey:       parsedUri.Rkey,
+				CreatedAt:  createdAt,
+			}
+			if opts.EngagementBelow <= 0 {
+				report.Matched = append(report.Matched, candidate)
+				continue
+			}
+
+			// getPosts takes at most 25 uris
+			pending = append(pending, candidate)
+			if len(pending) == 25 {
+				matched, err := c.filterLowEngagement(ctx, pending, opts.EngagementBelow)
+				if err != nil {
+					return report, fmt.Errorf("RepoPurge error (filterLowEngagement): %v", err)
+				}
+				report.Matched = append(report.Matched, matched...)
+				pending = pending[:0]
+			}
+		}
+		if len(pending) > 0 {
+			matched, err := c.filterLowEngagement(ctx, pending, opts.EngagementBelow)
+			if err != nil {
+				return report, fmt.Errorf("RepoPurge error (filterLowEngagement): %v", err)
+			}
+			report.Matched = append(report.Matched, matched...)
+		}
+	}
+
+	if opts.DryRun {
+		return report, nil
+	}
+
+	logger.Println("Purging", len(report.Matched), "records from repo")
+
+	for i := 0; i < len(report.Matched); i += batchSize {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return report, fmt.Errorf("RepoPurge error: %v", ctx.Err())
+			case <-time.After(batchDelay):
+			}
+		}
+
+		batch := c.NewWriteBatch()
+		for _, candidate := range report.Matched[i:min(i+batchSize, len(report.Matched))] {
+			batch.Delete(candidate.Collection, candidate.Rkey)
+		}
+		results, err := batch.Commit(ctx)
+		report.Deleted += len(results)
+		if err != nil {
+			return report, fmt.Errorf("RepoPurge error (Commit): %v", err)
+		}
+
+		if opts.OnProgress != nil {
+			opts.OnProgress(PurgeProgress{Deleted: report.Deleted, Total: len(report.Matched)})
+		}
+	}
+	return report, nil
+}
+
+// Get the candidates with fewer likes + reposts + replies + quotes than threshold, at most 25 at once.
+func (c *Client) filterLowEngagement(ctx context.Context, candidates []PurgeCandidate, threshold int64) ([]PurgeCandidate, error) {
+	uris := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		uris = append(uris, candidate.Uri)
+	}
+	results, err := bsky.FeedGetPosts(ctx, c.xrpcClient, uris)
+	if err != nil {
+		return nil, fmt.Errorf("filterLowEngagement error (FeedGetPosts): %v", err)
+	}
+
+	engagement := make(map[string]int64, len(results.Posts))
+	for _, postView := range results.Posts {
+		engagement[postView.Uri] = derefInt64(postView.LikeCount) + derefInt64(postView.RepostCount) +
+			derefInt64(postView.ReplyCount) + derefInt64(postView.QuoteCount)
+	}
+
+	var matched []PurgeCandidate
+	for _, candidate := range candidates {
+		// posts without a post view (e.g. not yet indexed) are kept
+		if count, ok := engagement[candidate.Uri]; ok && count < threshold {
+			matched = append(matched, candidate)
+		}
+	}
+	return matched, nil
+}
+
+// Get the creation time and (for posts) the text of a listed record.
+func purgeRecordInfo(record *atproto.RepoListRecords_Record) (time.Time, string) {
+	if record.Value == nil {
+		return time.Time{}, ""
+	}
+
+	var createdAt, text string
+	switch val := record.Value.Val.(type) {
+	case *bsky.FeedPost:
+		createdAt, text = val.CreatedAt, val.Text
+	case *bsky.FeedLike:
+		createdAt = val.CreatedAt
+	case *bsky.FeedRepost:
+		createdAt = val.CreatedAt
+	case *bsky.GraphFollow:
+		createdAt = val.CreatedAt
+	}
+
+	datetime, err := syntax.ParseDatetimeLenient(createdAt)
+	if err != nil {
+		return time.Time{}, text
+	}
+	return datetime.Time(), text
+}
+
+// Get the uri of the post pinned to the bots profile, or an empty string if there is none.
+func (c *Client) getPinnedPostUri(ctx context.Context) (string, error) {
+	profileRecord, err := atproto.RepoGetRecord(ctx, c.xrpcClient, "", "app.bsky.actor.profile", c.Did, "self")
+	var xrpcErr *xrpc.XRPCError
+	if errors.As(err, &xrpcErr) && xrpcErr.ErrStr == "RecordNotFound" {
+		// without a profile record, there is no pinned post
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("getPinnedPostUri error (RepoGetRecord): %v", err)
+	}
+
+	var actorProfile bsky.ActorProfile
+	if err := decodeRecordAsLexicon(profileRecord.Value, &actorProfile); err != nil {
+		return "", fmt.Errorf("getPinnedPostUri error (DecodeRecordAsLexicon): %v", err)
+	}
+	if actorProfile.PinnedPost == nil {
+		return "", nil
+	}
+	return actorProfile.PinnedPost.Uri, nil
+}
@@ -0,0 +1,46 @@
+package botsky
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+func TestGetPinnedPostUri(t *testing.T) {
+	profile := map[string]any{"$type": "app.bsky.actor.profile", "displayName": "bot"}
+	status, errName := 0, ""
+	pds := newFakePds(t, map[string]http.HandlerFunc{
+		"com.atproto.repo.getRecord": func(w http.ResponseWriter, r *http.Request) {
+			if status != 0 {
+				writeXrpcError(w, status, errName)
+				return
+			}
+			writeJson(w, map[string]any{"uri": "at://" + testDid + "/app.bsky.actor.profile/self", "value": profile})
+		},
+	})
+	c := &Client{xrpcClient: &xrpc.Client{Client: pds.server.Client(), Host: pds.server.URL}, Did: testDid}
+	ctx := context.Background()
+
+	if uri, err := c.getPinnedPostUri(ctx); err != nil || uri != "" {
+		t.Fatalf("got %q, %v for a profile without pinned post", uri, err)
+	}
+
+	pinned := "at://" + testDid + "/app.bsky.feed.post/1"
+	profile["pinnedPost"] = map[string]any{"uri": pinned, "cid": "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"}
+	if uri, err := c.getPinnedPostUri(ctx); err != nil || uri != pinned {
+		t.Fatalf("got %q, %v, want %q", uri, err, pinned)
+	}
+
+	// an account without profile record has no pinned post
+	status, errName = http.StatusBadRequest, "RecordNotFound"
+	if uri, err := c.getPinnedPostUri(ctx); err != nil || uri != "" {
+		t.Fatalf("got %q, %v for a missing profile", uri, err)
+	}
+
+	status, errName = http.StatusInternalServerError, "InternalServerError"
+	if _, err := c.getPinnedPostUri(ctx); err == nil {
+		t.Fatal("other errors are ignored")
+	}
+}
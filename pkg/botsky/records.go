@@ -0,0 +1,230 @@
+package botsky
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+
+	util "github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// Generic record access for arbitrary lexicon collections.
+//
+// The typed indigo API functions can only decode records of lexicons registered with indigo, so the functions in this
+// file talk to the com.atproto.repo.* endpoints directly and (de)serialize records as JSON. This works both for
+// indigo types (e.g. bsky.FeedPost) and for plain Go structs describing custom lexicons (e.g. dev.ourteam.botState).
+
+// A record decoded as type T, together with its location and version.
+type Record[T any] struct {
+	Uri   string
+	Cid   string
+	Value T
+}
+
+type recordOutput struct {
+	Uri   string          `json:"uri"`
+	Cid   string          `json:"cid"`
+	Value json.RawMessage `json:"value"`
+}
+
+type listRecordsOutput struct {
+	Cursor  *string         `json:"cursor,omitempty"`
+	Records []*recordOutput `json:"records"`
+}
+
+type writeRecordOutput struct {
+	Uri string `json:"uri"`
+	Cid string `json:"cid"`
+}
+
+// Parse the given record uri and resolve its authority (handle or DID) to a DID.
+//
+// Returns the DID, collection, and rkey of the record.
+func (c *Client) parseRecordUri(ctx context.Context, recordUri string) (string, string, string, error) {
+	parsedUri, err := util.ParseAtUri(recordUri)
+	if err != nil {
+		return "", "", "", fmt.Errorf("parseRecordUri error (ParseAtUri): %v", err)
+	}
+	did, err := c.ResolveHandle(ctx, parsedUri.Did)
+	if err != nil {
+		return "", "", "", fmt.Errorf("parseRecordUri error (ResolveHandle): %v", err)
+	}
+	return did, parsedUri.Collection, parsedUri.Rkey, nil
+}
+
+// Get the record at the given uri and decode it as type T.
+//
+// E.g. record, err := botsky.GetRecord[bsky.FeedPost](ctx, client, postUri)
+func GetRecord[T any](ctx context.Context, c *Client, recordUri string) (*Record[T], error) {
+	did, collection, rkey, err := c.parseRecordUri(ctx, recordUri)
+	if err != nil {
+		return nil, fmt.Errorf("GetRecord error (parseRecordUri): %v", err)
+	}
+
+	var output recordOutput
+	params := map[string]any{
+		"repo":       did,
+		"collection": collection,
+		"rkey":       rkey,
+	}
+	if err := c.xrpcClient.Do(ctx, xrpc.Query, "", "com.atproto.repo.getRecord", params, nil, &output); err != nil {
+		return nil, fmt.Errorf("GetRecord error (getRecord): %v", err)
+	}
+
+	return decodeRecordOutput[T](&output)
+}
+
+// Iterate over all records of the given collection in the given repo, decoded as type T.
+//
+// Records are fetched lazily, one page at a time. Iteration stops after the first error.
+// Pass a Cursor to resume a previous iteration, or nil to start at the beginning.
+func ListRecords[T any](ctx context.Context, c *Client, handleOrDid string, collection string, cursor *Cursor) iter.Seq2[*Record[T], error] {
+	return func(yield func(*Record[T], error) bool) {
+		// resolve once, not for every page
+		did, err := c.ResolveHandle(ctx, handleOrDid)
+		if err != nil {
+			yield(nil, fmt.Errorf("ListRecords error (ResolveHandle): %v", err))
+			return
+		}
+
+		outputs := paginate(ctx, cursor, func(ctx context.Context, cursor string) ([]*recordOutput, *string, error) {
+			var output listRecordsOutput
+			params := map[string]any{
+				"repo":       did,
+				"collection": collection,
+				"limit":      pageSize,
+			}
+			if cursor != "" {
+				params["cursor"] = cursor
+			}
+			if err := c.xrpcClient.Do(ctx, xrpc.Query, "", "com.atproto.repo.listRecords", params, nil, &output); err != nil {
+				return nil, nil, fmt.Errorf("ListRecords error (listRecords): %v", err)
+			}
+			return output.Records, output.Cursor, nil
+		})
+
+		for output, err := range outputs {
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			record, err := decodeRecordOutput[T](output)
+			if !yield(record, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Create a new record in the given collection of the bots repo. Leave rkey empty to let the server generate one.
+//
+// The record can be any value that serializes to a valid lexicon record as JSON. If it doesn't specify a $type,
+// the collection NSID is used.
+//
+// Returns the CID and Uri of the created record.
+func (c *Client) CreateRecord(ctx context.Context, collection string, rkey string, record any) (string, string, error) {
+	recordJson, err := recordAsJson(collection, record)
+	if err != nil {
+		return "", "", fmt.Errorf("CreateRecord error (recordAsJson): %v", err)
+	}
+
+	input := map[string]any{
+		"repo":       c.Did,
+		"collection": collection,
+		"record":     recordJson,
+	}
+	if rkey != "" {
+		input["rkey"] = rkey
+	}
+
+	var output writeRecordOutput
+	if err := c.xrpcClient.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.repo.createRecord", nil, input, &output); err != nil {
+		return "", "", fmt.Errorf("CreateRecord error (createRecord): %v", err)
+	}
+	return output.Cid, output.Uri, nil
+}
+
+// Create or overwrite the record with the given rkey in the given collection of the bots repo.
+//
+// If swapCid is not empty, the write fails unless the current version of the record has this CID.
+//
+// Returns the CID and Uri of the written record.
+func (c *Client) PutRecord(ctx context.Context, collection string, rkey string, record any, swapCid string) (string, string, error) {
+	recordJson, err := recordAsJson(collection, record)
+	if err != nil {
+		return "", "", fmt.Errorf("PutRecord error (recordAsJson): %v", err)
+	}
+
+	input := map[string]any{
+		"repo":       c.Did,
+		"collection": collection,
+		"rkey":       rkey,
+		"record":     recordJson,
+	}
+	if swapCid != "" {
+		input["swapRecord"] = swapCid
+	}
+
+	var output writeRecordOutput
+	if err := c.xrpcClient.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.repo.putRecord", nil, input, &output); err != nil {
+		return "", "", fmt.Errorf("PutRecord error (putRecord): %v", err)
+	}
+	return output.Cid, output.Uri, nil
+}
+
+// Delete the record at the given uri from the bots repo.
+//
+// If swapCid is not empty, the deletion fails unless the current version of the record has this CID.
+func (c *Client) DeleteRecord(ctx context.Context, recordUri string, swapCid string) error {
+	did, collection, rkey, err := c.parseRecordUri(ctx, recordUri)
+	if err != nil {
+		return fmt.Errorf("DeleteRecord error (parseRecordUri): %v", err)
+	}
+	if did != c.Did {
+		return fmt.Errorf("DeleteRecord error: record %s is not in the bots repo", recordUri)
+	}
+
+	input := map[string]any{
+		"repo":       c.Did,
+		"collection": collection,
+		"rkey":       rkey,
+	}
+	if swapCid != "" {
+		input["swapRecord"] = swapCid
+	}
+	if err := c.xrpcClient.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.repo.deleteRecord", nil, input, nil); err != nil {
+		return fmt.Errorf("DeleteRecord error (deleteRecord): %v", err)
+	}
+	return nil
+}
+
+// Decode the raw JSON value of a fetched record as type T.
+func decodeRecordOutput[T any](output *recordOutput) (*Record[T], error) {
+	record := &Record[T]{
+		Uri: output.Uri,
+		Cid: output.Cid,
+	}
+	if err := json.Unmarshal(output.Value, &record.Value); err != nil {
+		return nil, fmt.Errorf("decodeRecordOutput error (json.Unmarshal): %v", err)
+	}
+	return record, nil
+}
+
+// Serialize the record as JSON, setting its $type to the collection if it is missing or empty.
+func recordAsJson(collection string, record any) (json.RawMessage, error) {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("record must serialize to a JSON object: %v", err)
+	}
+	if typ, ok := fields["$type"]; !ok || string(typ) == `""` {
+		fields["$type"], _ = json.Marshal(collection)
+	}
+	return json.Marshal(fields)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"iter"
 	"log"
 
 	"github.com/bluesky-social/indigo/api/atproto"
@@ -12,14 +13,8 @@ import (
 	util "github.com/bluesky-social/indigo/util"
 )
 
-// TODO: download image function from embed/repo, using SyncGetBlob
-// useful to extract images e.g. from posts
-
 // TODO: info about user/profile
 
-// TODO: functions to get likes, follows, followers, posts, etc.
-// use curser to go through all pages
-
 // Get all collections available on the repo.
 func (c *Client) RepoGetCollections(ctx context.Context, handleOrDid string) ([]string, error) {
 	output, err := atproto.RepoDescribeRepo(ctx, c.xrpcClient, handleOrDid)
@@ -29,46 +24,28 @@ func (c *Client) RepoGetCollections(ctx context.Context, handleOrDid string) ([]
 	return output.Collections, nil
 }
 
-// Get all recors of the specified collection from the given repo.
-func (c *Client) RepoGetRecords(ctx context.Context, handleOrDid string, collection string, limit int) ([]*atproto.RepoListRecords_Record, error) {
-
-	var records []*atproto.RepoListRecords_Record
-	cursor, lastCid := "", ""
-
-	// iterate until we got all records
-	for {
-		// query repo for collection with updated cursor
-		output, err := atproto.RepoListRecords(ctx, c.xrpcClient, collection, cursor, 100, handleOrDid, false)
+// Iterate over all records of the specified collection in the given repo.
+//
+// Pages are fetched lazily. Pass a Cursor to resume a previous iteration, or nil to start at the beginning.
+func (c *Client) IterRecords(ctx context.Context, handleOrDid string, collection string, cursor *Cursor) iter.Seq2[*atproto.RepoListRecords_Record, error] {
+	return paginate(ctx, cursor, func(ctx context.Context, cursor string) ([]*atproto.RepoListRecords_Record, *string, error) {
+		output, err := atproto.RepoListRecords(ctx, c.xrpcClient, collection, cursor, pageSize, handleOrDid, false)
 		if err != nil {
-			return nil, fmt.Errorf("RepoGetRecords error (RepoListRecords): %v", err)
-		}
-
-		// stop if no records returned
-		// or we get one we've already seen (maybe a repo doesn't support cursor?)
-		if len(output.Records) == 0 || lastCid == output.Records[len(output.Records)-1].Cid {
-			break
+			return nil, nil, fmt.Errorf("IterRecords error (RepoListRecords): %v", err)
 		}
-		lastCid = output.Records[len(output.Records)-1].Cid
-		// store all record uris
-		records = append(records, output.Records...)
-
-		// if we have more records than the requested limit, stop
-		// limit -1 indicates no upper limit, i.e. get all record
-		if limit != -1 && len(records) >= limit {
-			break
-		}
-		// update cursor
-		cursor = *output.Cursor
-	}
+		return output.Records, output.Cursor, nil
+	})
+}
 
-	// don't return more than the requested limit
-	var end int
-	if limit == -1 {
-		end = len(records)
-	} else {
-		end = min(len(records), limit)
+// Get all records of the specified collection from the given repo.
+//
+// Set limit = -1 in order to get all records.
+func (c *Client) RepoGetRecords(ctx context.Context, handleOrDid string, collection string, limit int) ([]*atproto.RepoListRecords_Record, error) {
+	records, err := collect(c.IterRecords(ctx, handleOrDid, collection, nil), limit)
+	if err != nil {
+		return nil, fmt.Errorf("RepoGetRecords error (IterRecords): %v", err)
 	}
-	return records[:end], nil
+	return records, nil
 }
 
 // Get record uris from the repo in the given collection.
@@ -91,12 +68,14 @@ func (c *Client) RepoGetRecordUris(ctx context.Context, handleOrDid string, coll
 //
 // E.g. var post bsky.FeedPost;
 // RepoGetRecordAsType(ctx, postUri, &feedPost)
+//
+// Only works for lexicons known to indigo, use GetRecord for custom record types.
 func (c *Client) RepoGetRecordAsType(ctx context.Context, recordUri string, resultPointer cborUnmarshaler) error {
-	parsedUri, err := util.ParseAtUri(recordUri)
+	did, collection, rkey, err := c.parseRecordUri(ctx, recordUri)
 	if err != nil {
-		return fmt.Errorf("RepoGetCidOfRecord error (ParseAtUri): %v", err)
+		return fmt.Errorf("RepoGetRecordAsType error (parseRecordUri): %v", err)
 	}
-	record, err := atproto.RepoGetRecord(ctx, c.xrpcClient, "", parsedUri.Collection, parsedUri.Did, parsedUri.Rkey)
+	record, err := atproto.RepoGetRecord(ctx, c.xrpcClient, "", collection, did, rkey)
 	if err != nil {
 		return fmt.Errorf("RepoGetRecordAsType error (RepoGetRecord): %v", err)
 	}
@@ -107,11 +86,11 @@ func (c *Client) RepoGetRecordAsType(ctx context.Context, recordUri string, resu
 // Get the FeedPost struct and the post CID given its Uri.
 func (c *Client) RepoGetPostAndCid(ctx context.Context, postUri string) (bsky.FeedPost, string, error) {
 	var post bsky.FeedPost
-	parsedUri, err := util.ParseAtUri(postUri)
+	did, collection, rkey, err := c.parseRecordUri(ctx, postUri)
 	if err != nil {
-		return post, "", fmt.Errorf("RepoGetPostAndCid error (ParseAtUri): %v", err)
+		return post, "", fmt.Errorf("RepoGetPostAndCid error (parseRecordUri): %v", err)
 	}
-	record, err := atproto.RepoGetRecord(ctx, c.xrpcClient, "", parsedUri.Collection, parsedUri.Did, parsedUri.Rkey)
+	record, err := atproto.RepoGetRecord(ctx, c.xrpcClient, "", collection, did, rkey)
 	if err != nil {
 		return post, "", fmt.Errorf("RepoGetPostAndCid error (RepoGetRecord): %v", err)
 	}
@@ -139,6 +118,8 @@ func (c *Client) RepoDeletePost(ctx context.Context, postUri string) error {
 }
 
 // Delete all posts in the bots repository.
+//
+// Posts are deleted in batches through a WriteBatch.
 func (c *Client) RepoDeleteAllPosts(ctx context.Context) error {
 	postUris, err := c.RepoGetRecordUris(ctx, c.Handle, "app.bsky.feed.post", -1)
 	if err != nil {
@@ -146,11 +127,16 @@ func (c *Client) RepoDeleteAllPosts(ctx context.Context) error {
 	}
 	logger.Println("Deleting", len(postUris), "posts from repo")
 
+	batch := c.NewWriteBatch()
 	for _, uri := range postUris {
-		err = c.RepoDeletePost(ctx, uri)
+		parsedUri, err := util.ParseAtUri(uri)
 		if err != nil {
-			return fmt.Errorf("RepoDeleteAllPosts error (RepoDeletePost): %v", err)
+			return fmt.Errorf("RepoDeleteAllPosts error (ParseAtUri): %v", err)
 		}
+		batch.Delete("app.bsky.feed.post", parsedUri.Rkey)
+	}
+	if _, err := batch.Commit(ctx); err != nil {
+		return fmt.Errorf("RepoDeleteAllPosts error (Commit): %v", err)
 	}
 	return nil
 }
@@ -0,0 +1,77 @@
+package botsky
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// Sort orders for post searches.
+const (
+	SearchSortLatest = "latest"
+	SearchSortTop    = "top"
+)
+
+// Options for a post search. All fields are optional.
+type SearchOptions struct {
+	Sort     string    // SearchSortLatest or SearchSortTop, defaults to SearchSortLatest
+	Since    time.Time // only posts at or after this time
+	Until    time.Time // only posts before this time
+	Lang     string    // only posts in this language, e.g. "en"
+	Author   string    // only posts by this account (handle or DID)
+	Mentions string    // only posts mentioning this account (handle or DID)
+	Domain   string    // only posts linking to this domain
+	Url      string    // only posts linking to this url
+	Tags     []string  // only posts with all of these hashtags (without the #)
+}
+
+// Iterate over the posts matching a search query, in the order given by opts.Sort.
+//
+// The query uses the search syntax of the app, e.g. quotes for phrases or "from:handle".
+// Pages are fetched lazily. Pass a Cursor to resume a previous iteration, or nil to start at the beginning.
+func (c *Client) IterSearchPosts(ctx context.Context, query string, opts SearchOptions, cursor *Cursor) iter.Seq2[*RichPost, error] {
+	sort := opts.Sort
+	if sort == "" {
+		sort = SearchSortLatest
+	}
+	since := formatSearchTime(opts.Since)
+	until := formatSearchTime(opts.Until)
+
+	return paginate(ctx, cursor, func(ctx context.Context, cursor string) ([]*RichPost, *string, error) {
+		output, err := bsky.FeedSearchPosts(ctx, c.xrpcClient, opts.Author, cursor, opts.Domain, opts.Lang, pageSize, opts.Mentions, query, since, sort, opts.Tags, until, opts.Url)
+		if err != nil {
+			return nil, nil, fmt.Errorf("IterSearchPosts error (FeedSearchPosts): %v", err)
+		}
+		posts := make([]*RichPost, 0, len(output.Posts))
+		for _, postView := range output.Posts {
+			post, err := c.newRichPost(postView)
+			if err != nil {
+				return nil, nil, fmt.Errorf("IterSearchPosts error: %v", err)
+			}
+			posts = append(posts, post)
+		}
+		return posts, output.Cursor, nil
+	})
+}
+
+// Get the posts matching a search query, in the order given by opts.Sort.
+//
+// Set limit = -1 in order to get all results (the API stops after a few thousand).
+func (c *Client) SearchPosts(ctx context.Context, query string, opts SearchOptions, limit int) ([]*RichPost, error) {
+	posts, err := collect(c.IterSearchPosts(ctx, query, opts, nil), limit)
+	if err != nil {
+		return nil, fmt.Errorf("SearchPosts error: %v", err)
+	}
+	return posts, nil
+}
+
+// Format a time for the since/until parameters, or "" if it is not set.
+func formatSearchTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,219 @@
+package botsky
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/xrpc"
+	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// Resolve the PDS hosting the given account and return an (unauthenticated) xrpc client for it.
+//
+// Used for com.atproto.sync.* endpoints, which are served by the PDS of the account and don't require auth.
+func (c *Client) pdsClientFor(ctx context.Context, handleOrDid string) (*xrpc.Client, error) {
+	did, err := c.ResolveHandle(ctx, handleOrDid)
+	if err != nil {
+		return nil, fmt.Errorf("pdsClientFor error (ResolveHandle): %v", err)
+	}
+	parsedDid, err := syntax.ParseDID(did)
+	if err != nil {
+		return nil, fmt.Errorf("pdsClientFor error (ParseDID): %v", err)
+	}
+	ident, err := c.directory.LookupDID(ctx, parsedDid)
+	if err != nil {
+		return nil, fmt.Errorf("pdsClientFor error (LookupDID): %v", err)
+	}
+	pds := ident.PDSEndpoint()
+	if pds == "" {
+		return nil, fmt.Errorf("pdsClientFor error: no PDS endpoint declared for %s", did)
+	}
+	return &xrpc.Client{
+		Client: new(http.Client),
+		Host:   pds,
+	}, nil
+}
+
+// Export the full repository of the given account as a CAR file and write it to w.
+//
+// The repo is fetched from the PDS of the account via com.atproto.sync.getRepo. The response is streamed into w, so
+// large repos aren't held in memory.
+func (c *Client) SyncExportRepo(ctx context.Context, handleOrDid string, w io.Writer) error {
+	did, err := c.ResolveHandle(ctx, handleOrDid)
+	if err != nil {
+		return fmt.Errorf("SyncExportRepo error (ResolveHandle): %v", err)
+	}
+	pdsClient, err := c.pdsClientFor(ctx, did)
+	if err != nil {
+		return fmt.Errorf("SyncExportRepo error (pdsClientFor): %v", err)
+	}
+	// atproto.SyncGetRepo buffers the whole CAR, so the request is made directly
+	endpoint := strings.TrimSuffix(pdsClient.Host, "/") + "/xrpc/com.atproto.sync.getRepo?" + url.Values{"did": {did}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("SyncExportRepo error (NewRequest): %v", err)
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.car")
+	resp, err := pdsClient.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("SyncExportRepo error (Do): %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		xrpcErr := &xrpc.XRPCError{}
+		if err := json.NewDecoder(resp.Body).Decode(xrpcErr); err != nil {
+			xrpcErr.ErrStr = resp.Status
+		}
+		return fmt.Errorf("SyncExportRepo error (getRepo): %w", &xrpc.Error{StatusCode: resp.StatusCode, Wrapped: xrpcErr})
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("SyncExportRepo error (Copy): %v", err)
+	}
+	return nil
+}
+
+// A single record read from a repository snapshot.
+type CarRecord struct {
+	Collection string
+	Rkey       string
+	Cid        string
+	Record     cbg.CBORMarshaler // decoded record, nil if the lexicon is unknown to indigo (see Raw)
+	Raw        []byte            // raw CBOR encoding of the record
+}
+
+// An offline snapshot of a repository, read from a CAR file (e.g. one written by SyncExportRepo).
+type RepoSnapshot struct {
+	Did  string
+	Rev  string // revision of the commit the snapshot was taken at
+	repo *repo.Repo
+}
+
+// Read a repository snapshot from a CAR file. Doesn't require network access.
+func ReadRepoCar(ctx context.Context, r io.Reader) (*RepoSnapshot, error) {
+	repository, err := repo.ReadRepoFromCar(ctx, r)
+	if err != nil {
+		return nil, fmt.Errorf("ReadRepoCar error (ReadRepoFromCar): %v", err)
+	}
+	commit := repository.SignedCommit()
+	return &RepoSnapshot{
+		Did:  commit.Did,
+		Rev:  commit.Rev,
+		repo: repository,
+	}, nil
+}
+
+// Iterate over all records in the snapshot, in repository (MST) order.
+//
+// Set collection to an NSID to only walk the records of that collection, or leave it empty to walk all records.
+func (s *RepoSnapshot) Records(ctx context.Context, collection string) iter.Seq2[*CarRecord, error] {
+	return func(yield func(*CarRecord, error) bool) {
+		prefix := ""
+		if collection != "" {
+			prefix = collection + "/"
+		}
+
+		err := s.repo.ForEach(ctx, prefix, func(key string, recordCid cid.Cid) error {
+			// keys are sorted, so we are done once we leave the collection
+			if !strings.HasPrefix(key, prefix) {
+				return repo.ErrDoneIterating
+			}
+			record, err := s.readRecord(ctx, key, recordCid)
+			if !yield(record, err) || err != nil {
+				return repo.ErrDoneIterating
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, repo.ErrDoneIterating) {
+			yield(nil, fmt.Errorf("RepoSnapshot.Records error (ForEach): %v", err))
+		}
+	}
+}
+
+// Get a single record from the snapshot.
+func (s *RepoSnapshot) GetRecord(ctx context.Context, collection string, rkey string) (*CarRecord, error) {
+	recordCid, _, err := s.repo.GetRecordBytes(ctx, collection+"/"+rkey)
+	if err != nil {
+		return nil, fmt.Errorf("RepoSnapshot.GetRecord error (GetRecordBytes): %v", err)
+	}
+	return s.readRecord(ctx, collection+"/"+rkey, recordCid)
+}
+
+// Load and decode the record block with the given repo path and CID.
+func (s *RepoSnapshot) readRecord(ctx context.Context, path string, recordCid cid.Cid) (*CarRecord, error) {
+	collection, rkey, found := strings.Cut(path, "/")
+	if !found {
+		return nil, fmt.Errorf("readRecord error: invalid record path %s", path)
+	}
+	block, err := s.repo.Blockstore().Get(ctx, recordCid)
+	if err != nil {
+		return nil, fmt.Errorf("readRecord error (Get): %v", err)
+	}
+
+	record := &CarRecord{
+		Collection: collection,
+		Rkey:       rkey,
+		Cid:        recordCid.String(),
+		Raw:        block.RawData(),
+	}
+	// custom lexicons can't be decoded, but are still returned with their raw bytes
+	decoded, err := lexutil.CborDecodeValue(record.Raw)
+	if err == nil {
+		record.Record = decoded
+	} else if !errors.Is(err, lexutil.ErrUnrecognizedType) {
+		return nil, fmt.Errorf("readRecord error (CborDecodeValue): %v", err)
+	}
+	return record, nil
+}
+
+// Download a blob (e.g. an image or video) of the given account from its PDS via com.atproto.sync.getBlob.
+//
+// The CID of the downloaded data is verified against the requested CID.
+func (c *Client) GetBlob(ctx context.Context, handleOrDid string, blobCid string) ([]byte, error) {
+	did, err := c.ResolveHandle(ctx, handleOrDid)
+	if err != nil {
+		return nil, fmt.Errorf("GetBlob error (ResolveHandle): %v", err)
+	}
+	if _, err := cid.Decode(blobCid); err != nil {
+		return nil, fmt.Errorf("GetBlob error (Decode): %v", err)
+	}
+	pdsClient, err := c.pdsClientFor(ctx, did)
+	if err != nil {
+		return nil, fmt.Errorf("GetBlob error (pdsClientFor): %v", err)
+	}
+	data, err := atproto.SyncGetBlob(ctx, pdsClient, blobCid, did)
+	if err != nil {
+		return nil, fmt.Errorf("GetBlob error (SyncGetBlob): %v", err)
+	}
+	if err := verifyBlob(blobCid, data); err != nil {
+		return nil, fmt.Errorf("GetBlob error: %v", err)
+	}
+	return data, nil
+}
+
+// Check that the data matches the CID of the blob.
+func verifyBlob(blobCid string, data []byte) error {
+	expectedCid, err := cid.Decode(blobCid)
+	if err != nil {
+		return fmt.Errorf("verifyBlob error (Decode): %v", err)
+	}
+	actualCid, err := expectedCid.Prefix().Sum(data)
+	if err != nil {
+		return fmt.Errorf("verifyBlob error (Sum): %v", err)
+	}
+	if !actualCid.Equals(expectedCid) {
+		return fmt.Errorf("verifyBlob error: CID mismatch, expected %s but got %s", expectedCid, actualCid)
+	}
+	return nil
+}
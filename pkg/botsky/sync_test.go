@@ -0,0 +1,40 @@
+package botsky
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+func TestSyncExportRepo(t *testing.T) {
+	f := newMigrationFixture(t)
+	buf := new(bytes.Buffer)
+	if err := f.client(t).SyncExportRepo(context.Background(), testDid, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), f.repoCar) {
+		t.Fatalf("exported %q, want %q", buf.Bytes(), f.repoCar)
+	}
+}
+
+func TestSyncExportRepoError(t *testing.T) {
+	f := newMigrationFixture(t)
+	f.old = newFakePds(t, map[string]http.HandlerFunc{
+		"com.atproto.sync.getRepo": func(w http.ResponseWriter, r *http.Request) {
+			writeXrpcError(w, http.StatusBadRequest, "RepoTakendown")
+		},
+	})
+	buf := new(bytes.Buffer)
+	err := f.client(t).SyncExportRepo(context.Background(), testDid, buf)
+	var xrpcErr *xrpc.XRPCError
+	if !errors.As(err, &xrpcErr) || xrpcErr.ErrStr != "RepoTakendown" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatal("error response written to the export")
+	}
+}
@@ -0,0 +1,146 @@
+package botsky
+
+import (
+	"context"
+	"fmt"
+	"iter"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// A post in a thread, linked to its parent and replies.
+//
+// Posts that were deleted, are hidden by blocks or are of a type unknown to botsky are still part of the tree, but
+// have no Post.
+type ThreadNode struct {
+	Uri      string    // empty if the type of the post is unknown
+	Post     *RichPost // nil if the post is not found, blocked or of an unknown type
+	NotFound bool
+	Blocked  bool
+	Parent   *ThreadNode   // nil for the root, or if the parent wasn't loaded
+	Replies  []*ThreadNode // for ancestors of the requested post, only the reply leading to it
+}
+
+// Get the thread around a post: its ancestors up to parentHeight levels above, and its replies up to depth levels
+// below. Pass 0 to use the defaults of the API (depth 6, parentHeight 80).
+//
+// Returns the node of the requested post.
+func (c *Client) GetThread(ctx context.Context, postUri string, depth int, parentHeight int) (*ThreadNode, error) {
+	output, err := bsky.FeedGetPostThread(ctx, c.xrpcClient, int64(depth), int64(parentHeight), postUri)
+	if err != nil {
+		return nil, fmt.Errorf("GetThread error (FeedGetPostThread): %v", err)
+	}
+	if output.Thread == nil {
+		return nil, fmt.Errorf("GetThread error: empty thread")
+	}
+	node, err := c.newThreadNode(output.Thread.FeedDefs_ThreadViewPost, output.Thread.FeedDefs_NotFoundPost, output.Thread.FeedDefs_BlockedPost)
+	if err != nil {
+		return nil, fmt.Errorf("GetThread error: %v", err)
+	}
+	return node, nil
+}
+
+// Build the tree of a thread view, recursively including its parents and replies.
+func (c *Client) newThreadNode(view *bsky.FeedDefs_ThreadViewPost, notFound *bsky.FeedDefs_NotFoundPost, blocked *bsky.FeedDefs_BlockedPost) (*ThreadNode, error) {
+	switch {
+	case notFound != nil:
+		return &ThreadNode{Uri: notFound.Uri, NotFound: true}, nil
+	case blocked != nil:
+		return &ThreadNode{Uri: blocked.Uri, Blocked: true}, nil
+	case view == nil || view.Post == nil:
+		// e.g. a new type of thread item, handled like a post that isn't available
+		return &ThreadNode{}, nil
+	}
+
+	post, err := c.newRichPost(view.Post)
+	if err != nil {
+		return nil, err
+	}
+	node := &ThreadNode{Uri: view.Post.Uri, Post: post}
+
+	for _, reply := range view.Replies {
+		child, err := c.newThreadNode(reply.FeedDefs_ThreadViewPost, reply.FeedDefs_NotFoundPost, reply.FeedDefs_BlockedPost)
+		if err != nil {
+			return nil, err
+		}
+		child.Parent = node
+		node.Replies = append(node.Replies, child)
+	}
+
+	if view.Parent != nil {
+		parent, err := c.newThreadNode(view.Parent.FeedDefs_ThreadViewPost, view.Parent.FeedDefs_NotFoundPost, view.Parent.FeedDefs_BlockedPost)
+		if err != nil {
+			return nil, err
+		}
+		// the API only includes the direct line of parents, without their other replies
+		parent.Replies = append(parent.Replies, node)
+		node.Parent = parent
+	}
+	return node, nil
+}
+
+// Whether the post is available, i.e. neither deleted, blocked nor of an unknown type.
+func (n *ThreadNode) IsAvailable() bool {
+	return n.Post != nil
+}
+
+// The ancestors of the node, starting with its parent and ending with the root.
+func (n *ThreadNode) Ancestors() []*ThreadNode {
+	var ancestors []*ThreadNode
+	for parent := n.Parent; parent != nil; parent = parent.Parent {
+		ancestors = append(ancestors, parent)
+	}
+	return ancestors
+}
+
+// The topmost loaded node of the thread. This is the root post, unless parentHeight was too low to reach it.
+func (n *ThreadNode) Root() *ThreadNode {
+	root := n
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	return root
+}
+
+// Iterate over all loaded replies below the node (not including the node itself), breadth-first.
+func (n *ThreadNode) IterReplies() iter.Seq[*ThreadNode] {
+	return func(yield func(*ThreadNode) bool) {
+		queue := append([]*ThreadNode{}, n.Replies...)
+		for len(queue) > 0 {
+			node := queue[0]
+			queue = queue[1:]
+			if !yield(node) {
+				return
+			}
+			queue = append(queue, node.Replies...)
+		}
+	}
+}
+
+// The chain of posts the author of the root wrote as a thread: the root post, followed by the authors replies to
+// themself, down as far as loaded. If a post has multiple such replies, the first one is followed.
+//
+// Returns nil if the root is not available.
+func (n *ThreadNode) AuthorThread() []*RichPost {
+	root := n.Root()
+	if !root.IsAvailable() {
+		return nil
+	}
+	author := root.Post.AuthorDid
+
+	chain := []*RichPost{root.Post}
+	for node := root; node != nil; {
+		var next *ThreadNode
+		for _, reply := range node.Replies {
+			if reply.IsAvailable() && reply.Post.AuthorDid == author {
+				next = reply
+				break
+			}
+		}
+		if next != nil {
+			chain = append(chain, next.Post)
+		}
+		node = next
+	}
+	return chain
+}
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"syscall"
@@ -23,6 +24,27 @@ import (
 
 var logger = log.New(os.Stdout, "", log.LstdFlags|log.Lshortfile)
 
+// Write data to the file at path atomically: it is written to a temporary file first, which then replaces the file.
+// The file is thus never left half-written if the process dies.
+func WriteFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("WriteFileAtomic error (CreateTemp): %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("WriteFileAtomic error (Write): %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("WriteFileAtomic error (Close): %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("WriteFileAtomic error (Rename): %v", err)
+	}
+	return nil
+}
+
 // Convenience function to sleep for a number of seconds.
 func Sleep(seconds int) {
 	time.Sleep(time.Duration(seconds) * time.Second)
@@ -246,3 +268,11 @@ func WaitUntilCancel() {
 	<-sigChan
 	fmt.Println("\nCancelled")
 }
+
+// Dereference an optional count, treating nil as 0.
+func derefInt64(p *int64) int64 {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
@@ -0,0 +1,165 @@
+package listeners
+
+import (
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/api/chat"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Kinds of chat events.
+const (
+	ChatMessageCreated  = "messageCreated"
+	ChatMessageDeleted  = "messageDeleted"
+	ChatMessageRead     = "messageRead"
+	ChatReactionAdded   = "reactionAdded"
+	ChatReactionRemoved = "reactionRemoved"
+	ChatConvoBegun      = "convoBegun"
+	ChatConvoAccepted   = "convoAccepted"
+	ChatConvoLeft       = "convoLeft"
+	ChatConvoMuted      = "convoMuted"
+	ChatConvoUnmuted    = "convoUnmuted"
+)
+
+// An event in one of the bots conversations, decoded from the chat log.
+type ChatEvent struct {
+	Kind     string // one of the Chat* constants
+	ConvoId  string
+	Rev      string
+	Message  *ChatMessage  // the affected message, for message and reaction events
+	Reaction *ChatReaction // the added/removed reaction, for reaction events
+}
+
+// A chat message.
+type ChatMessage struct {
+	Id        string
+	Rev       string
+	SenderDid string
+	SentAt    time.Time
+	Text      string
+	Facets    []*bsky.RichtextFacet
+	Embed     *chat.ConvoDefs_MessageView_Embed
+	Deleted   bool // deleted messages have no content
+}
+
+// A reaction (emoji) to a chat message.
+type ChatReaction struct {
+	Value     string
+	SenderDid string
+	CreatedAt time.Time
+}
+
+// Convert a chat log entry into a ChatEvent. Returns nil for unknown log types.
+func newChatEvent(elem *chat.ConvoGetLog_Output_Logs_Elem) *ChatEvent {
+	switch {
+	case elem.ConvoDefs_LogCreateMessage != nil:
+		log := elem.ConvoDefs_LogCreateMessage
+		event := &ChatEvent{Kind: ChatMessageCreated, ConvoId: log.ConvoId, Rev: log.Rev}
+		if log.Message != nil {
+			event.Message = newChatMessage(log.Message.ConvoDefs_MessageView, log.Message.ConvoDefs_DeletedMessageView)
+		}
+		return event
+	case elem.ConvoDefs_LogDeleteMessage != nil:
+		log := elem.ConvoDefs_LogDeleteMessage
+		event := &ChatEvent{Kind: ChatMessageDeleted, ConvoId: log.ConvoId, Rev: log.Rev}
+		if log.Message != nil {
+			event.Message = newChatMessage(log.Message.ConvoDefs_MessageView, log.Message.ConvoDefs_DeletedMessageView)
+		}
+		return event
+	case elem.ConvoDefs_LogReadMessage != nil:
+		log := elem.ConvoDefs_LogReadMessage
+		event := &ChatEvent{Kind: ChatMessageRead, ConvoId: log.ConvoId, Rev: log.Rev}
+		if log.Message != nil {
+			event.Message = newChatMessage(log.Message.ConvoDefs_MessageView, log.Message.ConvoDefs_DeletedMessageView)
+		}
+		return event
+	case elem.ConvoDefs_LogAddReaction != nil:
+		log := elem.ConvoDefs_LogAddReaction
+		event := &ChatEvent{Kind: ChatReactionAdded, ConvoId: log.ConvoId, Rev: log.Rev, Reaction: newChatReaction(log.Reaction)}
+		if log.Message != nil {
+			event.Message = newChatMessage(log.Message.ConvoDefs_MessageView, log.Message.ConvoDefs_DeletedMessageView)
+		}
+		return event
+	case elem.ConvoDefs_LogRemoveReaction != nil:
+		log := elem.ConvoDefs_LogRemoveReaction
+		event := &ChatEvent{Kind: ChatReactionRemoved, ConvoId: log.ConvoId, Rev: log.Rev, Reaction: newChatReaction(log.Reaction)}
+		if log.Message != nil {
+			event.Message = newChatMessage(log.Message.ConvoDefs_MessageView, log.Message.ConvoDefs_DeletedMessageView)
+		}
+		return event
+	case elem.ConvoDefs_LogBeginConvo != nil:
+		return &ChatEvent{Kind: ChatConvoBegun, ConvoId: elem.ConvoDefs_LogBeginConvo.ConvoId, Rev: elem.ConvoDefs_LogBeginConvo.Rev}
+	case elem.ConvoDefs_LogAcceptConvo != nil:
+		return &ChatEvent{Kind: ChatConvoAccepted, ConvoId: elem.ConvoDefs_LogAcceptConvo.ConvoId, Rev: elem.ConvoDefs_LogAcceptConvo.Rev}
+	case elem.ConvoDefs_LogLeaveConvo != nil:
+		return &ChatEvent{Kind: ChatConvoLeft, ConvoId: elem.ConvoDefs_LogLeaveConvo.ConvoId, Rev: elem.ConvoDefs_LogLeaveConvo.Rev}
+	case elem.ConvoDefs_LogMuteConvo != nil:
+		return &ChatEvent{Kind: ChatConvoMuted, ConvoId: elem.ConvoDefs_LogMuteConvo.ConvoId, Rev: elem.ConvoDefs_LogMuteConvo.Rev}
+	case elem.ConvoDefs_LogUnmuteConvo != nil:
+		return &ChatEvent{Kind: ChatConvoUnmuted, ConvoId: elem.ConvoDefs_LogUnmuteConvo.ConvoId, Rev: elem.ConvoDefs_LogUnmuteConvo.Rev}
+	}
+	return nil
+}
+
+// Convert a message view (or deleted message view) into a ChatMessage.
+func newChatMessage(view *chat.ConvoDefs_MessageView, deleted *chat.ConvoDefs_DeletedMessageView) *ChatMessage {
+	switch {
+	case view != nil:
+		msg := &ChatMessage{
+			Id:     view.Id,
+			Rev:    view.Rev,
+			SentAt: parseChatTime(view.SentAt),
+			Text:   view.Text,
+			Facets: view.Facets,
+			Embed:  view.Embed,
+		}
+		if view.Sender != nil {
+			msg.SenderDid = view.Sender.Did
+		}
+		return msg
+	case deleted != nil:
+		msg := &ChatMessage{
+			Id:      deleted.Id,
+			Rev:     deleted.Rev,
+			SentAt:  parseChatTime(deleted.SentAt),
+			Deleted: true,
+		}
+		if deleted.Sender != nil {
+			msg.SenderDid = deleted.Sender.Did
+		}
+		return msg
+	}
+	return nil
+}
+
+func newChatReaction(view *chat.ConvoDefs_ReactionView) *ChatReaction {
+	if view == nil {
+		return nil
+	}
+	reaction := &ChatReaction{Value: view.Value, CreatedAt: parseChatTime(view.CreatedAt)}
+	if view.Sender != nil {
+		reaction.SenderDid = view.Sender.Did
+	}
+	return reaction
+}
+
+// Parse a chat timestamp, returning the zero time if it is invalid.
+func parseChatTime(timestamp string) time.Time {
+	datetime, err := syntax.ParseDatetimeLenient(timestamp)
+	if err != nil {
+		return time.Time{}
+	}
+	return datetime.Time()
+}
+
+// DID of the account that caused the event (sender of the message or reaction), or an empty string if unknown.
+func (e *ChatEvent) ActorDid() string {
+	if e.Reaction != nil {
+		return e.Reaction.SenderDid
+	}
+	if e.Message != nil {
+		return e.Message.SenderDid
+	}
+	return ""
+}
@@ -2,23 +2,200 @@ package listeners
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/davhofer/botsky/pkg/botsky"
-
-	"github.com/bluesky-social/indigo/api/chat"
+	"sync"
 )
 
-// Instantiation of a Listener for handling chat logs/events.
+// Number of handled events remembered for dedupe.
+const chatDedupeSize = 500
+
+// Options for a PollingChatListener.
+type ChatListenerOptions struct {
+	// Ignore the persisted cursor and only deliver logs from now on. By default, the listener replays everything after
+	// the persisted cursor (or starts from now if there is none).
+	StartFromNow bool
+	// Also deliver events caused by the bot itself (its own messages and reactions). These are dropped by default.
+	IncludeOwnMessages bool
+}
+
+// Instantiation of a Listener for handling chat events.
+//
+// Chat log entries are decoded into ChatEvents. Use a ChatRouter to register handlers per event type.
+//
+// The chat log cursor only advances once all handlers are done with the polled events, and is persisted in a
+// CheckpointStore together with the recently handled events. After a restart, the listener thus resumes where it left
+// off without answering the same message twice. If a handler fails on an event, the cursor stays before it and only
+// that event is delivered again, until it was handled or given up on (see SetMaxAttempts).
 type PollingChatListener struct {
-	Listener[chat.ConvoGetLog_Output_Logs_Elem]
+	Listener[ChatEvent]
+	checkpoint *chatCheckpoint
 }
 
 // Returns an set up PollingChatListener.
-func NewPollingChatListener(ctx context.Context, client *botsky.Client) *PollingChatListener {
-	return &PollingChatListener{*NewListener(ctx, client, "PollingChatListener", pollChatLogs)}
+//
+// The cursor is persisted in the given store. If store is nil, it is only kept in memory.
+func NewPollingChatListener(client *botsky.Client, store CheckpointStore, opts ChatListenerOptions) *PollingChatListener {
+	if store == nil {
+		store = NewMemoryCheckpointStore()
+	}
+	checkpoint := &chatCheckpoint{
+		store:        store,
+		key:          "chat:" + client.Did,
+		startFromNow: opts.StartFromNow,
+		includeOwn:   opts.IncludeOwnMessages,
+		handled:      make(map[string]bool),
+	}
+	l := &PollingChatListener{*NewListener(client, "PollingChatListener", checkpoint.poll), checkpoint}
+	l.ackEventsFunc = checkpoint.ack
+	l.retryEventsFunc = checkpoint.retry
+	l.deliveryTracker.key = chatEventKey
+	return l
+}
+
+// Tracks the chat log cursor and the recently handled events.
+type chatCheckpoint struct {
+	store        CheckpointStore
+	key          string
+	startFromNow bool
+	includeOwn   bool
+	loaded       bool
+	state        chatCheckpointState
+	next         string          // cursor after the logs currently being handled
+	retrying     bool            // whether a handler failed on one of the logs currently being handled
+	handled      map[string]bool // set of state.Handled
+	mutex        sync.Mutex
 }
 
-// Get all new chat logs since the last check.
-func pollChatLogs(ctx context.Context, client *botsky.Client) ([]*chat.ConvoGetLog_Output_Logs_Elem, error) {
+// Persisted state of a PollingChatListener.
+type chatCheckpointState struct {
+	Cursor  string   `json:"cursor"`
+	Handled []string `json:"handled"` // keys of recently handled events, oldest first
+}
+
+// Get all new chat events since the last check, skipping events that were already handled.
+func (c *chatCheckpoint) poll(ctx context.Context, client *botsky.Client) ([]*ChatEvent, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.loaded {
+		if err := c.load(ctx, client); err != nil {
+			return nil, err
+		}
+	}
+
 	// the "update seen" part happens automatically through updating of the cursor
-	return client.ChatGetRecentLogs(ctx)
+	logs, next, err := client.ChatGetLogs(ctx, c.state.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	c.next = next
+	c.retrying = false
+
+	var fresh []*ChatEvent
+	for _, elem := range logs {
+		event := newChatEvent(elem)
+		if event == nil {
+			continue
+		}
+		if c.handled[chatEventKey(event)] {
+			continue
+		}
+		if !c.includeOwn && event.ActorDid() == client.Did {
+			continue
+		}
+		fresh = append(fresh, event)
+	}
+	if len(fresh) == 0 && next != c.state.Cursor {
+		// nothing to handle, so there won't be an ack
+		c.state.Cursor = next
+		client.SetChatCursor(next)
+		if err := c.save(ctx); err != nil {
+			return nil, fmt.Errorf("poll error: %v", err)
+		}
+	}
+	return fresh, nil
+}
+
+// Remember the handled events and persist them. The cursor advances past the polled logs unless a handler failed on
+// one of them.
+func (c *chatCheckpoint) ack(ctx context.Context, client *botsky.Client, events []*ChatEvent) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, event := range events {
+		if key := chatEventKey(event); !c.handled[key] {
+			c.handled[key] = true
+			c.state.Handled = append(c.state.Handled, key)
+		}
+	}
+	for len(c.state.Handled) > chatDedupeSize {
+		delete(c.handled, c.state.Handled[0])
+		c.state.Handled = c.state.Handled[1:]
+	}
+	if !c.retrying {
+		c.state.Cursor = c.next
+		client.SetChatCursor(c.next)
+	}
+
+	if err := c.save(ctx); err != nil {
+		return fmt.Errorf("ack error: %v", err)
+	}
+	return nil
+}
+
+// Keep the cursor before the polled logs after a handler failed, so the failed events are polled again. The handled
+// ones are skipped then.
+func (c *chatCheckpoint) retry(events []*ChatEvent) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.retrying = true
+}
+
+// Load the persisted state, or start from the current end of the chat log.
+func (c *chatCheckpoint) load(ctx context.Context, client *botsky.Client) error {
+	value, err := c.store.Load(ctx, c.key)
+	if err != nil {
+		return fmt.Errorf("load error (Load): %v", err)
+	}
+	if value != "" {
+		if err := json.Unmarshal([]byte(value), &c.state); err != nil {
+			return fmt.Errorf("load error (Unmarshal): %v", err)
+		}
+		for _, id := range c.state.Handled {
+			c.handled[id] = true
+		}
+	}
+
+	if c.startFromNow || c.state.Cursor == "" {
+		// skip everything up to now
+		_, cursor, err := client.ChatGetLogs(ctx, "")
+		if err != nil {
+			return fmt.Errorf("load error (ChatGetLogs): %v", err)
+		}
+		c.state.Cursor = cursor
+		if err := c.save(ctx); err != nil {
+			return fmt.Errorf("load error: %v", err)
+		}
+	}
+	c.loaded = true
+	return nil
+}
+
+// Persist the state.
+func (c *chatCheckpoint) save(ctx context.Context) error {
+	value, err := json.Marshal(c.state)
+	if err != nil {
+		return fmt.Errorf("save error (Marshal): %v", err)
+	}
+	if err := c.store.Save(ctx, c.key, string(value)); err != nil {
+		return fmt.Errorf("save error (Save): %v", err)
+	}
+	return nil
+}
+
+// Identifies a chat event across polls of the log.
+func chatEventKey(event *ChatEvent) string {
+	return event.Kind + " " + event.ConvoId + " " + event.Rev
 }
@@ -0,0 +1,68 @@
+package listeners
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/davhofer/botsky/pkg/botsky"
+)
+
+func loadChatState(t *testing.T, store CheckpointStore, key string) chatCheckpointState {
+	t.Helper()
+	value, err := store.Load(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state chatCheckpointState
+	if err := json.Unmarshal([]byte(value), &state); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestChatCheckpointKeepsCursorBeforeFailedEvents(t *testing.T) {
+	store := NewMemoryCheckpointStore()
+	c := &chatCheckpoint{
+		store:   store,
+		key:     "chat",
+		loaded:  true,
+		state:   chatCheckpointState{Cursor: "c0"},
+		next:    "c1",
+		handled: make(map[string]bool),
+	}
+	client := &botsky.Client{}
+	answered := &ChatEvent{Kind: ChatMessageCreated, ConvoId: "convo", Rev: "1", Message: &ChatMessage{Id: "m1"}}
+	failed := &ChatEvent{Kind: ChatMessageCreated, ConvoId: "convo", Rev: "2", Message: &ChatMessage{Id: "m2"}}
+
+	// the listener calls retry before ack
+	c.retry([]*ChatEvent{failed})
+	if err := c.ack(context.Background(), client, []*ChatEvent{answered}); err != nil {
+		t.Fatal(err)
+	}
+	state := loadChatState(t, store, "chat")
+	if state.Cursor != "c0" {
+		t.Fatalf("cursor moved past a failed event to %s", state.Cursor)
+	}
+	if !slices.Equal(state.Handled, []string{chatEventKey(answered)}) {
+		t.Fatalf("handled %v", state.Handled)
+	}
+	// the answered message is skipped when the logs are polled again, also after a restart
+	if !c.handled[chatEventKey(answered)] || c.handled[chatEventKey(failed)] {
+		t.Fatal("unexpected handled events")
+	}
+
+	// the next poll delivers the failed event again
+	c.next, c.retrying = "c2", false
+	if err := c.ack(context.Background(), client, []*ChatEvent{failed}); err != nil {
+		t.Fatal(err)
+	}
+	state = loadChatState(t, store, "chat")
+	if state.Cursor != "c2" || client.ChatCursor() != "c2" {
+		t.Fatalf("cursor %s not advanced", state.Cursor)
+	}
+	if len(state.Handled) != 2 {
+		t.Fatalf("handled %v", state.Handled)
+	}
+}
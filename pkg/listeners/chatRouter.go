@@ -0,0 +1,131 @@
+package listeners
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/davhofer/botsky/pkg/botsky"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Predicate deciding whether a chat event gets passed to a handler.
+type ChatFilter func(*ChatEvent) bool
+
+// Routes the events of a PollingChatListener to handlers registered per event kind.
+//
+// Handlers are called once per event (instead of once per batch), in chronological order.
+type ChatRouter struct {
+	routes map[string][]EventHandler[*ChatEvent]
+	mutex  sync.Mutex
+}
+
+// Returns a ChatRouter registered as a handler of the given listener.
+func NewChatRouter(listener *PollingChatListener) (*ChatRouter, error) {
+	r := &ChatRouter{
+		routes: make(map[string][]EventHandler[*ChatEvent]),
+	}
+	if err := listener.RegisterHandler("chatRouter", r.handle); err != nil {
+		return nil, fmt.Errorf("NewChatRouter error (RegisterHandler): %v", err)
+	}
+	return r, nil
+}
+
+// Call the handler for every new message that passes all filters.
+func (r *ChatRouter) OnMessage(handler EventHandler[*ChatEvent], filters ...ChatFilter) {
+	r.On(ChatMessageCreated, handler, filters...)
+}
+
+// Call the handler for every deleted message that passes all filters.
+func (r *ChatRouter) OnMessageDeleted(handler EventHandler[*ChatEvent], filters ...ChatFilter) {
+	r.On(ChatMessageDeleted, handler, filters...)
+}
+
+// Call the handler for every reaction added to a message that passes all filters.
+func (r *ChatRouter) OnReactionAdded(handler EventHandler[*ChatEvent], filters ...ChatFilter) {
+	r.On(ChatReactionAdded, handler, filters...)
+}
+
+// Call the handler for every reaction removed from a message that passes all filters.
+func (r *ChatRouter) OnReactionRemoved(handler EventHandler[*ChatEvent], filters ...ChatFilter) {
+	r.On(ChatReactionRemoved, handler, filters...)
+}
+
+// Call the handler for every new conversation that passes all filters.
+func (r *ChatRouter) OnConvoBegun(handler EventHandler[*ChatEvent], filters ...ChatFilter) {
+	r.On(ChatConvoBegun, handler, filters...)
+}
+
+// Call the handler for every accepted conversation (request) that passes all filters.
+func (r *ChatRouter) OnConvoAccepted(handler EventHandler[*ChatEvent], filters ...ChatFilter) {
+	r.On(ChatConvoAccepted, handler, filters...)
+}
+
+// Call the handler for every left conversation that passes all filters.
+func (r *ChatRouter) OnConvoLeft(handler EventHandler[*ChatEvent], filters ...ChatFilter) {
+	r.On(ChatConvoLeft, handler, filters...)
+}
+
+// Call the handler for every event of the given kind (one of the Chat* constants) that passes all filters.
+func (r *ChatRouter) On(kind string, handler EventHandler[*ChatEvent], filters ...ChatFilter) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.routes[kind] = append(r.routes[kind], func(ctx context.Context, client *botsky.Client, event *ChatEvent) error {
+		for _, filter := range filters {
+			if !filter(event) {
+				return nil
+			}
+		}
+		return handler(ctx, client, event)
+	})
+}
+
+// Listener handler dispatching every event to the routes of its kind.
+//
+// A failing handler doesn't stop the other events from being handled. Errors are returned per event (as EventErrors), so
+// only the failed events are delivered again.
+func (r *ChatRouter) handle(ctx context.Context, client *botsky.Client, events []*ChatEvent) error {
+	r.mutex.Lock()
+	routes := make(map[string][]EventHandler[*ChatEvent], len(r.routes))
+	for kind, handlers := range r.routes {
+		routes[kind] = slices.Clone(handlers)
+	}
+	r.mutex.Unlock()
+
+	var errs []error
+	for i, event := range events {
+		var eventErrs []error
+		for _, handler := range routes[event.Kind] {
+			if err := handler(ctx, client, event); err != nil {
+				eventErrs = append(eventErrs, fmt.Errorf("%s %s: %w", event.Kind, event.ConvoId, err))
+			}
+		}
+		if len(eventErrs) > 0 {
+			errs = append(errs, &EventError{Index: i, Err: errors.Join(eventErrs...)})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Only pass events caused by one of the given DIDs (sender of the message or reaction).
+func FromSender(dids ...string) ChatFilter {
+	return func(event *ChatEvent) bool {
+		return slices.Contains(dids, event.ActorDid())
+	}
+}
+
+// Only pass events of one of the given conversations.
+func InConvo(convoIds ...string) ChatFilter {
+	return func(event *ChatEvent) bool {
+		return slices.Contains(convoIds, event.ConvoId)
+	}
+}
+
+// Only pass events whose message contains the given text (case-insensitive). Events without a message are dropped.
+func MessageContains(text string) ChatFilter {
+	text = strings.ToLower(text)
+	return func(event *ChatEvent) bool {
+		return event.Message != nil && strings.Contains(strings.ToLower(event.Message.Text), text)
+	}
+}
@@ -0,0 +1,39 @@
+package listeners
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/davhofer/botsky/pkg/botsky"
+)
+
+func TestChatRouterFailsOnlyFailedEvents(t *testing.T) {
+	r := &ChatRouter{routes: make(map[string][]EventHandler[*ChatEvent])}
+	handled := make(map[string]int)
+	r.OnMessage(func(ctx context.Context, client *botsky.Client, event *ChatEvent) error {
+		handled[event.ConvoId]++
+		if event.ConvoId == "bad" {
+			return errors.New("bad convo")
+		}
+		return nil
+	})
+
+	events := []*ChatEvent{
+		{Kind: ChatMessageCreated, ConvoId: "a"},
+		{Kind: ChatMessageCreated, ConvoId: "bad"},
+		{Kind: ChatMessageCreated, ConvoId: "b"},
+	}
+	err := r.handle(context.Background(), nil, events)
+	if err == nil {
+		t.Fatal("no error for the failed event")
+	}
+	errs := splitEventErrors(err, len(events))
+	if errs[0] != nil || errs[1] == nil || errs[2] != nil {
+		t.Fatalf("unexpected errors per event %v", errs)
+	}
+	// the failing event doesn't stop the others
+	if handled["a"] != 1 || handled["bad"] != 1 || handled["b"] != 1 {
+		t.Fatalf("unexpected calls %v", handled)
+	}
+}
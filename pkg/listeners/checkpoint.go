@@ -0,0 +1,134 @@
+package listeners
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/davhofer/botsky/pkg/botsky"
+	"os"
+	"sync"
+)
+
+// Persists the position of a listener (e.g. the newest handled notification) so it can resume after a restart.
+//
+// Checkpoints are opaque strings stored under a key. Load returns an empty string if no checkpoint was saved yet (or
+// it was deleted). Deleting a key that doesn't exist is not an error.
+type CheckpointStore interface {
+	Load(ctx context.Context, key string) (string, error)
+	Save(ctx context.Context, key string, checkpoint string) error
+	Delete(ctx context.Context, key string) error
+}
+
+// CheckpointStore keeping checkpoints in memory only, i.e. they are lost when the process exits.
+type MemoryCheckpointStore struct {
+	checkpoints map[string]string
+	mutex       sync.Mutex
+}
+
+// Returns an empty MemoryCheckpointStore.
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: make(map[string]string)}
+}
+
+func (s *MemoryCheckpointStore) Load(ctx context.Context, key string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.checkpoints[key], nil
+}
+
+func (s *MemoryCheckpointStore) Save(ctx context.Context, key string, checkpoint string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.checkpoints[key] = checkpoint
+	return nil
+}
+
+func (s *MemoryCheckpointStore) Delete(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.checkpoints, key)
+	return nil
+}
+
+// CheckpointStore keeping all checkpoints in a single JSON file.
+//
+// The file is rewritten atomically on every save, so it is never left half-written if the process dies.
+type FileCheckpointStore struct {
+	Path  string
+	mutex sync.Mutex
+}
+
+// Returns a FileCheckpointStore using the file at the given path. The file is created on the first save.
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{Path: path}
+}
+
+func (s *FileCheckpointStore) Load(ctx context.Context, key string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	checkpoints, err := s.read()
+	if err != nil {
+		return "", fmt.Errorf("FileCheckpointStore.Load error: %v", err)
+	}
+	return checkpoints[key], nil
+}
+
+func (s *FileCheckpointStore) Save(ctx context.Context, key string, checkpoint string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	checkpoints, err := s.read()
+	if err != nil {
+		return fmt.Errorf("FileCheckpointStore.Save error: %v", err)
+	}
+	checkpoints[key] = checkpoint
+	if err := s.write(checkpoints); err != nil {
+		return fmt.Errorf("FileCheckpointStore.Save error: %v", err)
+	}
+	return nil
+}
+
+func (s *FileCheckpointStore) Delete(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	checkpoints, err := s.read()
+	if err != nil {
+		return fmt.Errorf("FileCheckpointStore.Delete error: %v", err)
+	}
+	if _, ok := checkpoints[key]; !ok {
+		return nil
+	}
+	delete(checkpoints, key)
+	if err := s.write(checkpoints); err != nil {
+		return fmt.Errorf("FileCheckpointStore.Delete error: %v", err)
+	}
+	return nil
+}
+
+// Write all checkpoints to the file.
+func (s *FileCheckpointStore) write(checkpoints map[string]string) error {
+	data, err := json.MarshalIndent(checkpoints, "", "  ")
+	if err != nil {
+		return fmt.Errorf("write error (MarshalIndent): %v", err)
+	}
+	if err := botsky.WriteFileAtomic(s.Path, data); err != nil {
+		return fmt.Errorf("write error: %v", err)
+	}
+	return nil
+}
+
+// Read all checkpoints from the file. A missing file means there are no checkpoints yet.
+func (s *FileCheckpointStore) read() (map[string]string, error) {
+	checkpoints := make(map[string]string)
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoints, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &checkpoints); err != nil {
+		return nil, err
+	}
+	return checkpoints, nil
+}
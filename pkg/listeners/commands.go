@@ -0,0 +1,316 @@
+package listeners
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/davhofer/botsky/pkg/botsky"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Type of a command argument.
+type ArgType string
+
+const (
+	ArgString ArgType = "string" // a single word
+	ArgInt    ArgType = "int"    // an integer
+	ArgHandle ArgType = "handle" // a handle (with or without @) or DID, resolved to a DID
+	ArgText   ArgType = "text"   // the rest of the message, must be the last argument
+)
+
+// Default prefix of commands, e.g. "/status".
+const DefaultCommandPrefix = "/"
+
+var argTokenRegex = regexp.MustCompile(`\S+`)
+
+// An argument of a command.
+type CommandArg struct {
+	Name     string
+	Type     ArgType // defaults to ArgString
+	Optional bool    // optional arguments must come after all required ones
+}
+
+// Handler of a command.
+type CommandHandler func(context.Context, *botsky.Client, *CommandCall) error
+
+// A command that can be invoked through DMs or mentions, e.g. "/mute @handle".
+type Command struct {
+	Name        string // without the prefix
+	Description string // shown in the help text
+	Args        []CommandArg
+	AllowedDids []string // if set, only these accounts may use the command
+	Handler     CommandHandler
+}
+
+// An invocation of a command, with its parsed arguments.
+type CommandCall struct {
+	Command   *Command
+	SenderDid string
+	ConvoId   string     // conversation of the command, if it was sent as a DM
+	Mention   *PostEvent // mentioning post of the command, if it was sent as a mention
+	args      map[string]any
+}
+
+// Routes commands sent to the bot (through DMs or mentions) to their handlers.
+//
+// Messages that don't start with the prefix are ignored. Unknown commands, missing permissions and invalid arguments
+// are answered with an explanation. A "help" command listing all commands available to the sender is built in.
+type CommandRouter struct {
+	Prefix   string
+	commands map[string]*Command
+	order    []string
+	mutex    sync.Mutex
+}
+
+// Returns a CommandRouter using the DefaultCommandPrefix.
+//
+// Register it with ChatRouter.OnMessage(r.ChatHandler()) and/or NotificationRouter.OnMention(r.MentionHandler()).
+func NewCommandRouter() *CommandRouter {
+	r := &CommandRouter{
+		Prefix:   DefaultCommandPrefix,
+		commands: make(map[string]*Command),
+	}
+	r.Register(Command{
+		Name:        "help",
+		Description: "list all commands",
+		Handler: func(ctx context.Context, client *botsky.Client, call *CommandCall) error {
+			return call.Reply(ctx, client, r.HelpText(call.SenderDid))
+		},
+	})
+	return r
+}
+
+// Register a command. Registering a command with an existing name replaces it.
+func (r *CommandRouter) Register(cmd Command) error {
+	if cmd.Name == "" || strings.ContainsFunc(cmd.Name, isSpace) {
+		return fmt.Errorf("Register error: invalid command name '%s'", cmd.Name)
+	}
+	if cmd.Handler == nil {
+		return fmt.Errorf("Register error: command '%s' has no handler", cmd.Name)
+	}
+	cmd.Args = slices.Clone(cmd.Args)
+	optional := false
+	for i, arg := range cmd.Args {
+		if arg.Type == "" {
+			cmd.Args[i].Type = ArgString
+		}
+		if arg.Type == ArgText && i != len(cmd.Args)-1 {
+			return fmt.Errorf("Register error: text argument '%s' must be the last argument", arg.Name)
+		}
+		if optional && !arg.Optional {
+			return fmt.Errorf("Register error: required argument '%s' after optional argument", arg.Name)
+		}
+		optional = arg.Optional
+	}
+
+	name := strings.ToLower(cmd.Name)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, exists := r.commands[name]; !exists {
+		r.order = append(r.order, name)
+	}
+	r.commands[name] = &cmd
+	return nil
+}
+
+// Usage line of the command, e.g. "/mute <handle> [reason...]".
+func (r *CommandRouter) Usage(cmd *Command) string {
+	usage := r.Prefix + cmd.Name
+	for _, arg := range cmd.Args {
+		name := arg.Name
+		if arg.Type == ArgText {
+			name += "..."
+		}
+		if arg.Optional {
+			usage += " [" + name + "]"
+		} else {
+			usage += " <" + name + ">"
+		}
+	}
+	return usage
+}
+
+// Help text listing all commands the given account is allowed to use.
+func (r *CommandRouter) HelpText(senderDid string) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	lines := []string{"Commands:"}
+	for _, name := range r.order {
+		cmd := r.commands[name]
+		if !cmd.isAllowed(senderDid) {
+			continue
+		}
+		line := r.Usage(cmd)
+		if cmd.Description != "" {
+			line += " - " + cmd.Description
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Chat handler running the commands in new messages. Use with ChatRouter.OnMessage.
+func (r *CommandRouter) ChatHandler() EventHandler[*ChatEvent] {
+	return func(ctx context.Context, client *botsky.Client, event *ChatEvent) error {
+		if event.Message == nil || event.Message.Deleted {
+			return nil
+		}
+		call := &CommandCall{SenderDid: event.Message.SenderDid, ConvoId: event.ConvoId}
+		return r.execute(ctx, client, call, event.Message.Text)
+	}
+}
+
+// Mention handler running the commands in mentions, e.g. "@bot.bsky.social /status". Use with
+// NotificationRouter.OnMention.
+func (r *CommandRouter) MentionHandler() EventHandler[*PostEvent] {
+	return func(ctx context.Context, client *botsky.Client, mention *PostEvent) error {
+		if mention.Author == nil {
+			return nil
+		}
+		// skip the leading mentions
+		text := mention.Post.Text
+		for {
+			text = strings.TrimLeftFunc(text, isSpace)
+			if !strings.HasPrefix(text, "@") {
+				break
+			}
+			_, text = cutSpace(text)
+		}
+		call := &CommandCall{SenderDid: mention.Author.Did, Mention: mention}
+		return r.execute(ctx, client, call, text)
+	}
+}
+
+// Parse and run the command in the given text.
+func (r *CommandRouter) execute(ctx context.Context, client *botsky.Client, call *CommandCall, text string) error {
+	text = strings.TrimSpace(text)
+	if r.Prefix == "" || !strings.HasPrefix(text, r.Prefix) {
+		return nil
+	}
+	name, rest := cutSpace(strings.TrimPrefix(text, r.Prefix))
+	if name == "" {
+		return nil
+	}
+
+	r.mutex.Lock()
+	cmd, ok := r.commands[strings.ToLower(name)]
+	r.mutex.Unlock()
+	if !ok {
+		return call.Reply(ctx, client, fmt.Sprintf("unknown command %s%s, send %shelp for a list of commands", r.Prefix, name, r.Prefix))
+	}
+	if !cmd.isAllowed(call.SenderDid) {
+		return call.Reply(ctx, client, fmt.Sprintf("you are not allowed to use %s%s", r.Prefix, cmd.Name))
+	}
+
+	args, err := parseArgs(ctx, client, cmd.Args, rest)
+	if err != nil {
+		return call.Reply(ctx, client, fmt.Sprintf("%v\nusage: %s", err, r.Usage(cmd)))
+	}
+	call.Command = cmd
+	call.args = args
+	return cmd.Handler(ctx, client, call)
+}
+
+// Parse the arguments of a command from the text following its name.
+func parseArgs(ctx context.Context, client *botsky.Client, specs []CommandArg, text string) (map[string]any, error) {
+	tokens := argTokenRegex.FindAllStringIndex(text, -1)
+	args := make(map[string]any, len(specs))
+	for i, spec := range specs {
+		if i >= len(tokens) {
+			if !spec.Optional {
+				return nil, fmt.Errorf("missing argument %s", spec.Name)
+			}
+			break
+		}
+		token := text[tokens[i][0]:tokens[i][1]]
+		switch spec.Type {
+		case ArgText:
+			args[spec.Name] = strings.TrimSpace(text[tokens[i][0]:])
+			return args, nil
+		case ArgInt:
+			value, err := strconv.ParseInt(token, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("argument %s must be a number", spec.Name)
+			}
+			args[spec.Name] = value
+		case ArgHandle:
+			did := strings.TrimPrefix(token, "@")
+			if !strings.HasPrefix(did, "did:") {
+				resolved, err := client.ResolveHandle(ctx, did)
+				if err != nil {
+					return nil, fmt.Errorf("unknown handle %s", token)
+				}
+				did = resolved
+			}
+			args[spec.Name] = did
+		default:
+			args[spec.Name] = token
+		}
+	}
+	if len(tokens) > len(specs) {
+		return nil, errors.New("too many arguments")
+	}
+	return args, nil
+}
+
+func (cmd *Command) isAllowed(did string) bool {
+	return len(cmd.AllowedDids) == 0 || slices.Contains(cmd.AllowedDids, did)
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+// Split the text at the first whitespace.
+func cutSpace(text string) (string, string) {
+	i := strings.IndexFunc(text, isSpace)
+	if i < 0 {
+		return text, ""
+	}
+	return text[:i], text[i:]
+}
+
+// Whether the argument was given (optional arguments may be missing).
+func (c *CommandCall) Has(name string) bool {
+	_, ok := c.args[name]
+	return ok
+}
+
+// Value of a string or text argument, or an empty string if it is missing.
+func (c *CommandCall) String(name string) string {
+	value, _ := c.args[name].(string)
+	return value
+}
+
+// Value of an int argument, or 0 if it is missing.
+func (c *CommandCall) Int(name string) int64 {
+	value, _ := c.args[name].(int64)
+	return value
+}
+
+// DID of a handle argument, or an empty string if it is missing.
+func (c *CommandCall) Did(name string) string {
+	return c.String(name)
+}
+
+// Reply to the command where it was sent: in the conversation for DMs, with a reply post for mentions.
+func (c *CommandCall) Reply(ctx context.Context, client *botsky.Client, text string) error {
+	if c.ConvoId != "" {
+		if _, _, err := client.ChatConvoSendMessage(ctx, c.ConvoId, text); err != nil {
+			return fmt.Errorf("Reply error (ChatConvoSendMessage): %v", err)
+		}
+		return nil
+	}
+	if c.Mention != nil {
+		pb := botsky.NewPostBuilder(text).ReplyTo(c.Mention.Uri)
+		if _, _, err := client.Post(ctx, pb); err != nil {
+			return fmt.Errorf("Reply error (Post): %v", err)
+		}
+		return nil
+	}
+	return errors.New("Reply error: command has no origin to reply to")
+}
@@ -0,0 +1,146 @@
+package listeners
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Number of times an event is delivered to the handlers failing on it before it is given up on, by default.
+const defaultMaxAttempts = 5
+
+// Number of dead letters kept per listener. Older ones are dropped.
+const deadLetterLimit = 100
+
+// An event that kept failing in a handler and was given up on, see Listener.DeadLetters.
+type DeadLetter[EventT any] struct {
+	Event    *EventT
+	Attempts int
+	Err      error // errors of the last attempt, per failing handler
+	Time     time.Time
+}
+
+// Tracks events that failed in at least one handler, so that they are only delivered again to the handlers that failed
+// on them, and given up on after too many attempts.
+type deliveryTracker[EventT any] struct {
+	key         func(*EventT) string // identifies an event across polls, nil disables tracking
+	maxAttempts int
+	pending     map[string]*delivery // events that failed in some handler, by key
+	deadLetters []DeadLetter[EventT]
+	mutex       sync.Mutex
+}
+
+// Delivery state of a failed event.
+type delivery struct {
+	attempts  int
+	succeeded map[string]bool // ids of the handlers that already handled the event
+}
+
+func newDeliveryTracker[EventT any](key func(*EventT) string) deliveryTracker[EventT] {
+	return deliveryTracker[EventT]{
+		key:         key,
+		maxAttempts: defaultMaxAttempts,
+		pending:     make(map[string]*delivery),
+	}
+}
+
+// Whether the handler already handled the event in a previous attempt.
+func (t *deliveryTracker[EventT]) handled(event *EventT, handlerId string) bool {
+	if t.key == nil {
+		return false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	d, ok := t.pending[t.key(event)]
+	return ok && d.succeeded[handlerId]
+}
+
+// Record the result of a dispatch.
+//
+// Returns the events that are done, i.e. were handled by all handlers or given up on (these are also returned as dead
+// letters), and the events that failed and should be delivered again. Attempts are only counted if countAttempt is set,
+// e.g. not if the handlers failed because the listener was shutting down.
+func (t *deliveryTracker[EventT]) settle(events []*EventT, result dispatchResult, countAttempt bool) (done []*EventT, failed []*EventT, dead []DeadLetter[EventT]) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	ids := slices.Sorted(maps.Keys(result))
+	for i, event := range events {
+		var errs []error
+		for _, id := range ids {
+			if err := result[id][i]; err != nil {
+				errs = append(errs, fmt.Errorf("handler %s: %w", id, err))
+			}
+		}
+
+		if t.key == nil {
+			// can't recognize the event when it is delivered again, so there is nothing to track
+			if len(errs) == 0 {
+				done = append(done, event)
+			} else {
+				failed = append(failed, event)
+			}
+			continue
+		}
+
+		key := t.key(event)
+		if len(errs) == 0 {
+			delete(t.pending, key)
+			done = append(done, event)
+			continue
+		}
+
+		d, ok := t.pending[key]
+		if !ok {
+			d = &delivery{succeeded: make(map[string]bool)}
+			t.pending[key] = d
+		}
+		for _, id := range ids {
+			if result[id][i] == nil {
+				d.succeeded[id] = true
+			}
+		}
+		if countAttempt {
+			d.attempts++
+		}
+		if d.attempts < t.maxAttempts {
+			failed = append(failed, event)
+			continue
+		}
+
+		delete(t.pending, key)
+		letter := DeadLetter[EventT]{Event: event, Attempts: d.attempts, Err: errors.Join(errs...), Time: time.Now()}
+		t.deadLetters = append(t.deadLetters, letter)
+		if len(t.deadLetters) > deadLetterLimit {
+			t.deadLetters = t.deadLetters[len(t.deadLetters)-deadLetterLimit:]
+		}
+		dead = append(dead, letter)
+		done = append(done, event)
+	}
+	return done, failed, dead
+}
+
+// Set how often an event is delivered to the handlers failing on it before it is given up on and moved to the dead
+// letters. Set to 0 for the default (5).
+//
+// Events are recognized across polls by the listener, so this has no effect for listeners created with NewListener.
+func (t *deliveryTracker[EventT]) SetMaxAttempts(n int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if n <= 0 {
+		n = defaultMaxAttempts
+	}
+	t.maxAttempts = n
+}
+
+// The most recent events that were given up on after failing too often, oldest first.
+func (t *deliveryTracker[EventT]) DeadLetters() []DeadLetter[EventT] {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	letters := make([]DeadLetter[EventT], len(t.deadLetters))
+	copy(letters, t.deadLetters)
+	return letters
+}
@@ -0,0 +1,201 @@
+package listeners
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davhofer/botsky/pkg/botsky"
+)
+
+type testEvent struct {
+	id string
+}
+
+// Source of a test listener, acting like a checkpoint: events are polled until they are acked.
+type testSource struct {
+	queue   []*testEvent
+	acked   []string
+	retried []string
+	mutex   sync.Mutex
+}
+
+func (s *testSource) poll(ctx context.Context, client *botsky.Client) ([]*testEvent, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	events := s.queue
+	s.queue = nil
+	return events, nil
+}
+
+func (s *testSource) ack(ctx context.Context, client *botsky.Client, events []*testEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, event := range events {
+		s.acked = append(s.acked, event.id)
+	}
+	return nil
+}
+
+func (s *testSource) retry(events []*testEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, event := range events {
+		s.retried = append(s.retried, event.id)
+		// delivered again as a new value, like after polling it again
+		s.queue = append(s.queue, &testEvent{id: event.id})
+	}
+}
+
+func (s *testSource) ackedIds() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return slices.Clone(s.acked)
+}
+
+func newTestListener(source *testSource) *Listener[testEvent] {
+	l := NewListener(nil, "test", source.poll)
+	l.ackEventsFunc = source.ack
+	l.retryEventsFunc = source.retry
+	l.deliveryTracker.key = func(event *testEvent) string { return event.id }
+	l.PollingInterval = time.Millisecond
+	return l
+}
+
+// Counts the calls of a handler per event id.
+type callCounter struct {
+	calls map[string]int
+	mutex sync.Mutex
+}
+
+func (c *callCounter) add(events []*testEvent) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, event := range events {
+		c.calls[event.id]++
+	}
+}
+
+func (c *callCounter) get(id string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.calls[id]
+}
+
+func waitAcked(t *testing.T, source *testSource, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		acked := source.ackedIds()
+		if len(acked) >= n {
+			return acked
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("acked %v, want %d events", acked, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestListenerRetriesOnlyFailedHandlers(t *testing.T) {
+	source := &testSource{queue: []*testEvent{{"good"}, {"bad"}}}
+	l := newTestListener(source)
+	l.SetMaxAttempts(3)
+
+	succeeding := &callCounter{calls: make(map[string]int)}
+	failing := &callCounter{calls: make(map[string]int)}
+	l.RegisterHandler("succeeding", func(ctx context.Context, client *botsky.Client, events []*testEvent) error {
+		succeeding.add(events)
+		return nil
+	})
+	l.RegisterHandler("failing", func(ctx context.Context, client *botsky.Client, events []*testEvent) error {
+		failing.add(events)
+		var errs []error
+		for i, event := range events {
+			if event.id == "bad" {
+				errs = append(errs, &EventError{Index: i, Err: errors.New("bad event")})
+			}
+		}
+		return errors.Join(errs...)
+	})
+
+	if err := l.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	acked := waitAcked(t, source, 2)
+	if err := l.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(acked, []string{"good", "bad"}) {
+		t.Fatalf("acked %v", acked)
+	}
+	if n := succeeding.get("bad"); n != 1 {
+		t.Fatalf("succeeding handler got the failed event %d times, want 1", n)
+	}
+	if n := failing.get("good"); n != 1 {
+		t.Fatalf("failing handler got the good event %d times, want 1", n)
+	}
+	if n := failing.get("bad"); n != 3 {
+		t.Fatalf("failing handler got the bad event %d times, want 3", n)
+	}
+	if !slices.Equal(source.retried, []string{"bad", "bad"}) {
+		t.Fatalf("retried %v", source.retried)
+	}
+
+	letters := l.DeadLetters()
+	if len(letters) != 1 || letters[0].Event.id != "bad" || letters[0].Attempts != 3 || letters[0].Err == nil {
+		t.Fatalf("unexpected dead letters %+v", letters)
+	}
+}
+
+func TestListenerHandlerErrorFailsAllEvents(t *testing.T) {
+	source := &testSource{queue: []*testEvent{{"a"}, {"b"}}}
+	l := newTestListener(source)
+
+	calls := &callCounter{calls: make(map[string]int)}
+	l.RegisterHandler("flaky", func(ctx context.Context, client *botsky.Client, events []*testEvent) error {
+		calls.add(events)
+		if calls.get("a") == 1 {
+			return errors.New("temporary failure")
+		}
+		return nil
+	})
+
+	if err := l.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	acked := waitAcked(t, source, 2)
+	if err := l.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(acked, []string{"a", "b"}) {
+		t.Fatalf("acked %v", acked)
+	}
+	if calls.get("a") != 2 || calls.get("b") != 2 {
+		t.Fatalf("unexpected calls %v", calls.calls)
+	}
+	if len(l.DeadLetters()) != 0 {
+		t.Fatal("unexpected dead letters")
+	}
+}
+
+func TestSplitEventErrors(t *testing.T) {
+	errA := errors.New("a")
+	errs := splitEventErrors(errors.Join(&EventError{Index: 2, Err: errA}), 3)
+	if errs[0] != nil || errs[1] != nil || !errors.Is(errs[2], errA) {
+		t.Fatalf("unexpected split %v", errs)
+	}
+
+	// an index out of range fails all events
+	errs = splitEventErrors(errors.Join(&EventError{Index: 3, Err: errA}), 3)
+	for i, err := range errs {
+		if err == nil {
+			t.Fatalf("event %d didn't fail", i)
+		}
+	}
+}
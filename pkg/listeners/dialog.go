@@ -0,0 +1,507 @@
+package listeners
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/davhofer/botsky/pkg/botsky"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned by a step handler to end the dialog.
+const DialogEnd = ""
+
+// Store key of the list of conversations with a running dialog.
+const dialogIndexKey = "dialogs"
+
+// A multi-step conversation with a user in a DM, e.g. "ask question -> wait for answer -> confirm".
+type Dialog struct {
+	Name           string
+	Steps          []DialogStep  // the dialog begins with the first step
+	Timeout        time.Duration // inactivity after which the dialog expires, 0 means never
+	TimeoutMessage string        // optional, sent when an expired dialog is noticed
+	// Optional, called once the dialog ended.
+	OnComplete func(context.Context, *botsky.Client, *DialogContext) error
+}
+
+// A step of a dialog.
+type DialogStep struct {
+	Name   string
+	Prompt string // optional, sent when entering the step
+	// Optional, validates the users reply. If it returns an error, the error is sent to the user and the step is
+	// repeated.
+	Expect func(reply string) error
+	// Optional, handles the users reply and returns the name of the next step (or DialogEnd). By default, the reply
+	// is stored in DialogContext.Data under the name of the step, and the dialog moves on to the following step.
+	Handle func(ctx context.Context, client *botsky.Client, d *DialogContext, reply string) (string, error)
+}
+
+// State of a running dialog, passed to the step handlers.
+type DialogContext struct {
+	ConvoId string
+	UserDid string
+	Data    map[string]string // values collected during the dialog, persisted together with the dialog
+}
+
+// Persisted state of a running dialog.
+//
+// Messages may be delivered again if handling them failed. LastMessage and Prompted make sure a message is handled by
+// a step only once: when it is delivered again, only the parts that failed (sending the prompt, OnComplete) are redone.
+type dialogState struct {
+	Dialog      string            `json:"dialog"`
+	Step        string            `json:"step"` // DialogEnd while OnComplete is running
+	UserDid     string            `json:"userDid"`
+	Data        map[string]string `json:"data"`
+	UpdatedAt   time.Time         `json:"updatedAt"`
+	LastMessage string            `json:"lastMessage,omitempty"` // id of the message that led to the current step
+	Prompted    bool              `json:"prompted"`              // whether the prompt of the current step was sent
+}
+
+// Runs dialogs in chat conversations. There is at most one running dialog per conversation.
+//
+// The state of running dialogs is persisted in a CheckpointStore, so dialogs survive restarts of the bot. Messages are
+// routed to the dialogs through ChatHandler.
+type DialogManager struct {
+	store       CheckpointStore
+	dialogs     map[string]*Dialog
+	states      map[string]*dialogState // running dialogs by convo id, loaded on demand
+	mutex       sync.Mutex
+	running     map[string]bool // convo ids of all running dialogs, persisted under dialogIndexKey
+	indexLoaded bool
+	indexMutex  sync.Mutex // guards running and indexLoaded, held while the index is loaded or saved
+}
+
+// Returns a DialogManager persisting dialog state in the given store. If store is nil, it is only kept in memory.
+func NewDialogManager(store CheckpointStore) *DialogManager {
+	if store == nil {
+		store = NewMemoryCheckpointStore()
+	}
+	return &DialogManager{
+		store:   store,
+		dialogs: make(map[string]*Dialog),
+		states:  make(map[string]*dialogState),
+		running: make(map[string]bool),
+	}
+}
+
+// Register a dialog. Registering a dialog with an existing name replaces it.
+func (m *DialogManager) Register(dialog Dialog) error {
+	if dialog.Name == "" {
+		return errors.New("Register error: dialog has no name")
+	}
+	if len(dialog.Steps) == 0 {
+		return fmt.Errorf("Register error: dialog '%s' has no steps", dialog.Name)
+	}
+	var names []string
+	for _, step := range dialog.Steps {
+		if step.Name == DialogEnd || slices.Contains(names, step.Name) {
+			return fmt.Errorf("Register error: invalid or duplicate step name '%s' in dialog '%s'", step.Name, dialog.Name)
+		}
+		names = append(names, step.Name)
+	}
+	dialog.Steps = slices.Clone(dialog.Steps)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.dialogs[dialog.Name] = &dialog
+	return nil
+}
+
+// Begin the dialog with the given user in the given conversation, replacing any running dialog, and send the prompt
+// of the first step.
+func (m *DialogManager) Begin(ctx context.Context, client *botsky.Client, convoId string, userDid string, dialogName string) error {
+	m.mutex.Lock()
+	dialog, ok := m.dialogs[dialogName]
+	m.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("Begin error: unknown dialog '%s'", dialogName)
+	}
+
+	state := &dialogState{
+		Dialog:  dialogName,
+		Step:    dialog.Steps[0].Name,
+		UserDid: userDid,
+		Data:    make(map[string]string),
+	}
+	if err := m.enterStep(ctx, client, convoId, dialog, state); err != nil {
+		return fmt.Errorf("Begin error: %v", err)
+	}
+	return nil
+}
+
+// Cancel the running dialog in the given conversation, if any.
+func (m *DialogManager) Cancel(ctx context.Context, convoId string) error {
+	if err := m.setState(ctx, convoId, nil); err != nil {
+		return fmt.Errorf("Cancel error: %v", err)
+	}
+	return nil
+}
+
+// Whether a dialog is running in the given conversation.
+func (m *DialogManager) IsActive(ctx context.Context, convoId string) (bool, error) {
+	state, err := m.getState(ctx, convoId)
+	if err != nil {
+		return false, fmt.Errorf("IsActive error: %v", err)
+	}
+	return state != nil, nil
+}
+
+// Chat handler passing new messages to the running dialog of their conversation. Use with ChatRouter.OnMessage.
+//
+// Messages in conversations without a running dialog (or from other members than the user of the dialog) are passed
+// to fallback instead, which may be nil.
+func (m *DialogManager) ChatHandler(fallback EventHandler[*ChatEvent]) EventHandler[*ChatEvent] {
+	return func(ctx context.Context, client *botsky.Client, event *ChatEvent) error {
+		handled, err := m.handleMessage(ctx, client, event)
+		if err != nil || handled || fallback == nil {
+			return err
+		}
+		return fallback(ctx, client, event)
+	}
+}
+
+// Expire all running dialogs that have been inactive for longer than their timeout, sending their TimeoutMessage.
+// This includes dialogs started before a restart. Dialogs are also expired when a message arrives after the timeout,
+// this allows to clean up (and notify) stale conversations periodically.
+func (m *DialogManager) ExpireStale(ctx context.Context, client *botsky.Client) error {
+	m.indexMutex.Lock()
+	err := m.loadIndex(ctx)
+	convoIds := slices.Collect(maps.Keys(m.running))
+	m.indexMutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("ExpireStale error: %v", err)
+	}
+
+	var errs []error
+	for _, convoId := range convoIds {
+		state, err := m.getState(ctx, convoId)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if state == nil {
+			// the state is gone, e.g. because removing the dialog from the index failed
+			if err := m.updateIndex(ctx, convoId, false); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		m.mutex.Lock()
+		expired := m.isExpired(state)
+		m.mutex.Unlock()
+		if !expired {
+			continue
+		}
+		if err := m.expire(ctx, client, convoId); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("ExpireStale error: %v", err)
+	}
+	return nil
+}
+
+// Pass the message to the running dialog of its conversation. Returns whether the message belonged to a dialog.
+func (m *DialogManager) handleMessage(ctx context.Context, client *botsky.Client, event *ChatEvent) (bool, error) {
+	if event.Message == nil || event.Message.Deleted {
+		return false, nil
+	}
+	state, err := m.getState(ctx, event.ConvoId)
+	if err != nil || state == nil || state.UserDid != event.Message.SenderDid {
+		return false, err
+	}
+	// work on a copy, the cached state is only replaced once the step is done
+	copied := *state
+	copied.Data = maps.Clone(state.Data)
+	state = &copied
+
+	m.mutex.Lock()
+	dialog, ok := m.dialogs[state.Dialog]
+	expired := m.isExpired(state)
+	m.mutex.Unlock()
+	if !ok {
+		// the dialog isn't registered (anymore)
+		return false, m.setState(ctx, event.ConvoId, nil)
+	}
+	if expired {
+		// the message starts over, e.g. it may begin a new dialog
+		return false, m.expire(ctx, client, event.ConvoId)
+	}
+
+	d := &DialogContext{ConvoId: event.ConvoId, UserDid: state.UserDid, Data: state.Data}
+	redelivered := state.LastMessage != "" && state.LastMessage == event.Message.Id
+	if state.Step == DialogEnd {
+		// the steps are done but OnComplete failed before
+		if err := m.complete(ctx, client, event.ConvoId, dialog, d); err != nil {
+			return true, err
+		}
+		return redelivered, nil
+	}
+	if redelivered {
+		// delivered again after a failure, the step already handled the message
+		if !state.Prompted {
+			return true, m.prompt(ctx, client, event.ConvoId, dialog, state)
+		}
+		return true, nil
+	}
+
+	idx := slices.IndexFunc(dialog.Steps, func(step DialogStep) bool { return step.Name == state.Step })
+	if idx < 0 {
+		return true, m.setState(ctx, event.ConvoId, nil)
+	}
+	step := dialog.Steps[idx]
+	reply := strings.TrimSpace(event.Message.Text)
+	state.LastMessage = event.Message.Id
+
+	if step.Expect != nil {
+		if err := step.Expect(reply); err != nil {
+			// repeat the step
+			if _, _, err := client.ChatConvoSendMessage(ctx, event.ConvoId, err.Error()); err != nil {
+				return true, err
+			}
+			return true, m.enterStep(ctx, client, event.ConvoId, dialog, state)
+		}
+	}
+
+	next := DialogEnd
+	if idx+1 < len(dialog.Steps) {
+		next = dialog.Steps[idx+1].Name
+	}
+	if step.Handle != nil {
+		next, err = step.Handle(ctx, client, d, reply)
+		if err != nil {
+			return true, err
+		}
+	} else {
+		d.Data[step.Name] = reply
+	}
+
+	if next == DialogEnd {
+		if dialog.OnComplete == nil {
+			return true, m.setState(ctx, event.ConvoId, nil)
+		}
+		// remember that the steps are done, in case OnComplete fails
+		state.Step = DialogEnd
+		state.UpdatedAt = time.Now()
+		if err := m.setState(ctx, event.ConvoId, state); err != nil {
+			return true, err
+		}
+		return true, m.complete(ctx, client, event.ConvoId, dialog, d)
+	}
+	if !slices.ContainsFunc(dialog.Steps, func(step DialogStep) bool { return step.Name == next }) {
+		err := fmt.Errorf("dialog '%s' has no step '%s'", dialog.Name, next)
+		return true, errors.Join(err, m.setState(ctx, event.ConvoId, nil))
+	}
+	state.Step = next
+	return true, m.enterStep(ctx, client, event.ConvoId, dialog, state)
+}
+
+// Persist the state and send the prompt of its current step.
+func (m *DialogManager) enterStep(ctx context.Context, client *botsky.Client, convoId string, dialog *Dialog, state *dialogState) error {
+	state.UpdatedAt = time.Now()
+	state.Prompted = false
+	if err := m.setState(ctx, convoId, state); err != nil {
+		return err
+	}
+	return m.prompt(ctx, client, convoId, dialog, state)
+}
+
+// Send the prompt of the current step of the state and remember that it was sent.
+func (m *DialogManager) prompt(ctx context.Context, client *botsky.Client, convoId string, dialog *Dialog, state *dialogState) error {
+	for _, step := range dialog.Steps {
+		if step.Name == state.Step && step.Prompt != "" {
+			if _, _, err := client.ChatConvoSendMessage(ctx, convoId, step.Prompt); err != nil {
+				return err
+			}
+		}
+	}
+	// the state may be cached already, so update a copy
+	prompted := *state
+	prompted.Prompted = true
+	return m.setState(ctx, convoId, &prompted)
+}
+
+// Call OnComplete of the dialog and end it once OnComplete succeeded.
+func (m *DialogManager) complete(ctx context.Context, client *botsky.Client, convoId string, dialog *Dialog, d *DialogContext) error {
+	if dialog.OnComplete != nil {
+		if err := dialog.OnComplete(ctx, client, d); err != nil {
+			return err
+		}
+	}
+	return m.setState(ctx, convoId, nil)
+}
+
+// End the dialog of the given conversation and send its TimeoutMessage.
+func (m *DialogManager) expire(ctx context.Context, client *botsky.Client, convoId string) error {
+	state, err := m.getState(ctx, convoId)
+	if err != nil || state == nil {
+		return err
+	}
+	if err := m.setState(ctx, convoId, nil); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	dialog, ok := m.dialogs[state.Dialog]
+	m.mutex.Unlock()
+	if ok && dialog.TimeoutMessage != "" {
+		if _, _, err := client.ChatConvoSendMessage(ctx, convoId, dialog.TimeoutMessage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Must be called with the mutex held.
+func (m *DialogManager) isExpired(state *dialogState) bool {
+	dialog, ok := m.dialogs[state.Dialog]
+	return ok && dialog.Timeout > 0 && time.Since(state.UpdatedAt) > dialog.Timeout
+}
+
+// Get the state of the running dialog in the given conversation, loading it from the store if necessary.
+func (m *DialogManager) getState(ctx context.Context, convoId string) (*dialogState, error) {
+	m.mutex.Lock()
+	state, loaded := m.states[convoId]
+	m.mutex.Unlock()
+	if loaded {
+		return state, nil
+	}
+
+	value, err := m.store.Load(ctx, "dialog:"+convoId)
+	if err != nil {
+		return nil, fmt.Errorf("getState error (Load): %v", err)
+	}
+	if value == "" {
+		// not cached, so the map only grows with running dialogs
+		return nil, nil
+	}
+	state = &dialogState{}
+	if err := json.Unmarshal([]byte(value), state); err != nil {
+		return nil, fmt.Errorf("getState error (Unmarshal): %v", err)
+	}
+	if state.Data == nil {
+		state.Data = make(map[string]string)
+	}
+
+	m.mutex.Lock()
+	m.states[convoId] = state
+	m.mutex.Unlock()
+	return state, nil
+}
+
+// Set (or with nil, clear) and persist the state of the given conversation.
+func (m *DialogManager) setState(ctx context.Context, convoId string, state *dialogState) error {
+	if state == nil {
+		if err := m.store.Delete(ctx, "dialog:"+convoId); err != nil {
+			return fmt.Errorf("setState error (Delete): %v", err)
+		}
+		m.mutex.Lock()
+		delete(m.states, convoId)
+		m.mutex.Unlock()
+		return m.updateIndex(ctx, convoId, false)
+	}
+
+	value, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("setState error (Marshal): %v", err)
+	}
+	if err := m.store.Save(ctx, "dialog:"+convoId, string(value)); err != nil {
+		return fmt.Errorf("setState error (Save): %v", err)
+	}
+
+	m.mutex.Lock()
+	m.states[convoId] = state
+	m.mutex.Unlock()
+	return m.updateIndex(ctx, convoId, true)
+}
+
+// Add the conversation to (or remove it from) the persisted list of running dialogs.
+func (m *DialogManager) updateIndex(ctx context.Context, convoId string, running bool) error {
+	m.indexMutex.Lock()
+	defer m.indexMutex.Unlock()
+	if err := m.loadIndex(ctx); err != nil {
+		return fmt.Errorf("updateIndex error: %v", err)
+	}
+	if m.running[convoId] == running {
+		return nil
+	}
+
+	if running {
+		m.running[convoId] = true
+	} else {
+		delete(m.running, convoId)
+	}
+	if len(m.running) == 0 {
+		if err := m.store.Delete(ctx, dialogIndexKey); err != nil {
+			return fmt.Errorf("updateIndex error (Delete): %v", err)
+		}
+		return nil
+	}
+	value, err := json.Marshal(slices.Sorted(maps.Keys(m.running)))
+	if err != nil {
+		return fmt.Errorf("updateIndex error (Marshal): %v", err)
+	}
+	if err := m.store.Save(ctx, dialogIndexKey, string(value)); err != nil {
+		return fmt.Errorf("updateIndex error (Save): %v", err)
+	}
+	return nil
+}
+
+// Load the list of running dialogs from the store, once. Must be called with the indexMutex held.
+func (m *DialogManager) loadIndex(ctx context.Context) error {
+	if m.indexLoaded {
+		return nil
+	}
+	value, err := m.store.Load(ctx, dialogIndexKey)
+	if err != nil {
+		return fmt.Errorf("loadIndex error (Load): %v", err)
+	}
+	if value != "" {
+		var convoIds []string
+		if err := json.Unmarshal([]byte(value), &convoIds); err != nil {
+			return fmt.Errorf("loadIndex error (Unmarshal): %v", err)
+		}
+		for _, convoId := range convoIds {
+			m.running[convoId] = true
+		}
+	}
+	m.indexLoaded = true
+	return nil
+}
+
+// Reply to the user of the dialog.
+func (d *DialogContext) Reply(ctx context.Context, client *botsky.Client, text string) error {
+	if _, _, err := client.ChatConvoSendMessage(ctx, d.ConvoId, text); err != nil {
+		return fmt.Errorf("Reply error (ChatConvoSendMessage): %v", err)
+	}
+	return nil
+}
+
+// Expect one of the given replies (case-insensitive).
+func ExpectOneOf(options ...string) func(string) error {
+	return func(reply string) error {
+		for _, option := range options {
+			if strings.EqualFold(reply, option) {
+				return nil
+			}
+		}
+		return fmt.Errorf("please answer with one of: %s", strings.Join(options, ", "))
+	}
+}
+
+// Expect an integer reply.
+func ExpectNumber() func(string) error {
+	return func(reply string) error {
+		if _, err := strconv.ParseInt(reply, 10, 64); err != nil {
+			return errors.New("please answer with a number")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,85 @@
+package listeners
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/davhofer/botsky/pkg/botsky"
+)
+
+func TestDialogExpiresAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryCheckpointStore()
+	dialog := Dialog{Name: "ask", Steps: []DialogStep{{Name: "question"}}, Timeout: time.Millisecond}
+
+	m := NewDialogManager(store)
+	if err := m.Register(dialog); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Begin(ctx, nil, "convo", "did:plc:user", "ask"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// a new manager, like after a restart, knows the dialog only from the store
+	m = NewDialogManager(store)
+	if err := m.Register(dialog); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ExpireStale(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := store.Load(ctx, "dialog:convo"); value != "" {
+		t.Fatalf("dialog not expired: %s", value)
+	}
+	if value, _ := store.Load(ctx, dialogIndexKey); value != "" {
+		t.Fatalf("dialog still indexed: %s", value)
+	}
+}
+
+func TestDialogHandlesRedeliveredMessageOnce(t *testing.T) {
+	ctx := context.Background()
+	handled := 0
+	completed := 0
+	m := NewDialogManager(nil)
+	err := m.Register(Dialog{
+		Name: "ask",
+		Steps: []DialogStep{{
+			Name: "question",
+			Handle: func(ctx context.Context, client *botsky.Client, d *DialogContext, reply string) (string, error) {
+				handled++
+				return DialogEnd, nil
+			},
+		}},
+		OnComplete: func(ctx context.Context, client *botsky.Client, d *DialogContext) error {
+			completed++
+			if completed == 1 {
+				return errors.New("temporary failure")
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Begin(ctx, nil, "convo", "did:plc:user", "ask"); err != nil {
+		t.Fatal(err)
+	}
+
+	event := &ChatEvent{Kind: ChatMessageCreated, ConvoId: "convo", Message: &ChatMessage{Id: "m1", SenderDid: "did:plc:user", Text: "answer"}}
+	if ok, err := m.handleMessage(ctx, nil, event); !ok || err == nil {
+		t.Fatalf("handled %v, error %v", ok, err)
+	}
+	// the failed message is delivered again
+	if ok, err := m.handleMessage(ctx, nil, event); !ok || err != nil {
+		t.Fatalf("handled %v, error %v", ok, err)
+	}
+	if handled != 1 || completed != 2 {
+		t.Fatalf("step handled %d times, completed %d times", handled, completed)
+	}
+	if active, _ := m.IsActive(ctx, "convo"); active {
+		t.Fatal("dialog still running")
+	}
+}
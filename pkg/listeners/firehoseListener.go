@@ -0,0 +1,431 @@
+package listeners
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/davhofer/botsky/pkg/botsky"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/parallel"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/gorilla/websocket"
+	"github.com/ipfs/go-cid"
+	car "github.com/ipld/go-car"
+)
+
+// Default relay (Bluesky's main firehose).
+const DefaultRelayHost = "wss://bsky.network"
+
+// How often the cursor is persisted while events are coming in.
+const firehoseCheckpointInterval = time.Second
+
+// Options for a FirehoseListener.
+type FirehoseOptions struct {
+	RelayHost         string   // websocket url of the relay (or PDS), defaults to DefaultRelayHost
+	WantedCollections []string // only pass on record ops of these collections (NSIDs, prefixes like "app.bsky.graph.*" are allowed)
+	WantedDids        []string // only pass on events of these repos
+	Workers           int      // number of concurrently handled repos, defaults to 8
+	MaxQueued         int      // maximum number of received events waiting to be handled (across all repos), defaults to 1000
+}
+
+// Listener consuming the firehose (com.atproto.sync.subscribeRepos) of a relay.
+//
+// Commits are decoded into one RepoEvent per record op, and all ops of a commit are passed to the handlers together.
+// Events are handled by a bounded pool of workers: events of the same repo are handled in order, different repos in
+// parallel. Events waiting for a worker are queued, up to MaxQueued events in total; when the queue is full (e.g.
+// because a single repo produces events faster than they are handled), reading from the connection pauses until an
+// event has been handled.
+//
+// Handlers failing on an event get it again with exponential backoff, until they succeed or the event is given up on
+// (see SetMaxAttempts and DeadLetters); events of the same repo wait meanwhile.
+//
+// The seq cursor is persisted in a CheckpointStore. It only advances past events that have been fully handled, so
+// the listener resumes without gaps after a reconnect or restart (events may be delivered again though).
+type FirehoseListener struct {
+	streamListener
+	opts    FirehoseOptions
+	store   CheckpointStore
+	key     string
+	seqs    seqTracker
+	savedAt time.Time // when the cursor was last persisted
+	mutex   sync.Mutex
+}
+
+// Returns a set up FirehoseListener.
+//
+// The cursor is persisted in the given store. If store is nil, it is only kept in memory; on the first start the
+// listener then starts with live events.
+func NewFirehoseListener(client *botsky.Client, opts FirehoseOptions, store CheckpointStore) *FirehoseListener {
+	if opts.RelayHost == "" {
+		opts.RelayHost = DefaultRelayHost
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 8
+	}
+	if opts.MaxQueued <= 0 {
+		opts.MaxQueued = 1000
+	}
+	if store == nil {
+		store = NewMemoryCheckpointStore()
+	}
+
+	l := &FirehoseListener{
+		streamListener: newStreamListener(client, "FirehoseListener"),
+		opts:           opts,
+		store:          store,
+		key:            "firehose:" + opts.RelayHost,
+		seqs:           seqTracker{pending: make(map[int64]struct{})},
+	}
+	l.consume = l.consumeStream
+	l.loadCursor = l.loadStreamCursor
+	l.saveCursor = l.saveStreamCursor
+	return l
+}
+
+// Connect to the relay and handle events until the connection fails or connCtx is cancelled.
+//
+// Reports whether a connection was established.
+func (l *FirehoseListener) consumeStream(connCtx context.Context, ctx context.Context) (bool, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(connCtx, l.subscribeUrl(), nil)
+	if err != nil {
+		return false, fmt.Errorf("consumeStream error (Dial): %v", err)
+	}
+	defer conn.Close()
+
+	// events still queued when the previous connection dropped are replayed from the cursor
+	l.seqs.reset(l.seqs.watermark())
+
+	// HandleRepoStream shuts the scheduler down when it returns, which waits for running handlers
+	queue := make(chan struct{}, l.opts.MaxQueued)
+	scheduler := &trackingScheduler{
+		Scheduler: parallel.NewScheduler(l.opts.Workers, 0, l.opts.RelayHost, func(_ context.Context, xev *events.XRPCStreamEvent) error {
+			defer func() { <-queue }()
+			return l.handleEvent(connCtx, ctx, xev)
+		}),
+		seqs:  &l.seqs,
+		queue: queue,
+	}
+	if err := events.HandleRepoStream(connCtx, conn, scheduler, nil); err != nil {
+		return true, fmt.Errorf("consumeStream error (HandleRepoStream): %v", err)
+	}
+	return true, nil
+}
+
+// Url of the subscribeRepos endpoint, including the cursor to resume from.
+func (l *FirehoseListener) subscribeUrl() string {
+	endpoint := strings.TrimSuffix(l.opts.RelayHost, "/") + "/xrpc/com.atproto.sync.subscribeRepos"
+	if cursor := l.seqs.watermark(); cursor > 0 {
+		return endpoint + "?" + url.Values{"cursor": {strconv.FormatInt(cursor, 10)}}.Encode()
+	}
+	return endpoint
+}
+
+// Decode a firehose event, pass it to the handlers, and mark it as done.
+// Is called by the scheduler workers.
+func (l *FirehoseListener) handleEvent(connCtx context.Context, ctx context.Context, xev *events.XRPCStreamEvent) error {
+	seq := streamEventSeq(xev)
+	done := true
+	defer func() {
+		// events that weren't handled because the listener stopped must hold back the cursor
+		if seq == 0 || !done {
+			return
+		}
+		l.seqs.finish(seq)
+		if err := l.maybeSaveCursor(ctx); err != nil {
+			l.reportError("", err)
+		}
+	}()
+
+	repoEvents, err := l.decodeEvent(ctx, xev)
+	if err != nil {
+		return fmt.Errorf("handleEvent error: %v", err)
+	}
+	if len(repoEvents) > 0 {
+		done = l.deliver(connCtx, ctx, repoEvents)
+	}
+	return nil
+}
+
+// Convert a firehose event into RepoEvents, applying the filters.
+func (l *FirehoseListener) decodeEvent(ctx context.Context, xev *events.XRPCStreamEvent) ([]*RepoEvent, error) {
+	switch {
+	case xev.RepoCommit != nil:
+		commit := xev.RepoCommit
+		if !l.wantsDid(commit.Repo) {
+			return nil, nil
+		}
+		return decodeCommit(commit, l.wantsCollection)
+	case xev.RepoIdentity != nil:
+		identity := xev.RepoIdentity
+		if !l.wantsDid(identity.Did) {
+			return nil, nil
+		}
+		event := &RepoEvent{Kind: RepoEventIdentity, Did: identity.Did, Time: parseStreamTime(identity.Time), Identity: &IdentityChange{}}
+		if identity.Handle != nil {
+			event.Identity.Handle = *identity.Handle
+		}
+		return []*RepoEvent{event}, nil
+	case xev.RepoAccount != nil:
+		account := xev.RepoAccount
+		if !l.wantsDid(account.Did) {
+			return nil, nil
+		}
+		event := &RepoEvent{Kind: RepoEventAccount, Did: account.Did, Time: parseStreamTime(account.Time), Account: &AccountChange{Active: account.Active}}
+		if account.Status != nil {
+			event.Account.Status = *account.Status
+		}
+		return []*RepoEvent{event}, nil
+	}
+	return nil, nil
+}
+
+func (l *FirehoseListener) wantsDid(did string) bool {
+	return len(l.opts.WantedDids) == 0 || slices.Contains(l.opts.WantedDids, did)
+}
+
+func (l *FirehoseListener) wantsCollection(collection string) bool {
+	if len(l.opts.WantedCollections) == 0 {
+		return true
+	}
+	for _, wanted := range l.opts.WantedCollections {
+		if prefix, ok := strings.CutSuffix(wanted, "*"); ok && strings.HasPrefix(collection, prefix) {
+			return true
+		}
+		if wanted == collection {
+			return true
+		}
+	}
+	return false
+}
+
+// Decode the ops of a commit into RepoEvents, reading the records from the blocks CAR of the commit.
+func decodeCommit(commit *atproto.SyncSubscribeRepos_Commit, wantsCollection func(string) bool) ([]*RepoEvent, error) {
+	var blocks map[cid.Cid][]byte
+	var repoEvents []*RepoEvent
+	for _, op := range commit.Ops {
+		collection, rkey, found := strings.Cut(op.Path, "/")
+		if !found || !wantsCollection(collection) {
+			continue
+		}
+		commitOp := &CommitOp{
+			Rev:        commit.Rev,
+			Operation:  op.Action,
+			Collection: collection,
+			Rkey:       rkey,
+		}
+
+		if op.Cid != nil && op.Action != OpDelete {
+			commitOp.Cid = cid.Cid(*op.Cid).String()
+			// only read the CAR if there is a wanted record in it
+			if blocks == nil {
+				var err error
+				if blocks, err = readCarBlocks(commit.Blocks); err != nil {
+					return nil, fmt.Errorf("decodeCommit error (readCarBlocks): %v", err)
+				}
+			}
+			// records may be missing, e.g. for tooBig commits
+			if raw, ok := blocks[cid.Cid(*op.Cid)]; ok {
+				commitOp.Raw = raw
+				// custom lexicons can't be decoded, but are still passed on with their raw CBOR
+				record, err := lexutil.CborDecodeValue(raw)
+				if err == nil {
+					commitOp.Record = record
+				} else if !errors.Is(err, lexutil.ErrUnrecognizedType) {
+					return nil, fmt.Errorf("decodeCommit error (CborDecodeValue): %v", err)
+				}
+			}
+		}
+
+		repoEvents = append(repoEvents, &RepoEvent{
+			Kind:   RepoEventCommit,
+			Did:    commit.Repo,
+			Time:   parseStreamTime(commit.Time),
+			Commit: commitOp,
+		})
+	}
+	return repoEvents, nil
+}
+
+// Read all blocks of a CAR file into a map.
+func readCarBlocks(carBytes []byte) (map[cid.Cid][]byte, error) {
+	blocks := make(map[cid.Cid][]byte)
+	if len(carBytes) == 0 {
+		return blocks, nil
+	}
+	reader, err := car.NewCarReader(bytes.NewReader(carBytes))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		block, err := reader.Next()
+		if err == io.EOF {
+			return blocks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		blocks[block.Cid()] = block.RawData()
+	}
+}
+
+// Parse the timestamp of a firehose event, falling back to the current time.
+func parseStreamTime(timestamp string) time.Time {
+	datetime, err := syntax.ParseDatetimeLenient(timestamp)
+	if err != nil {
+		return time.Now()
+	}
+	return datetime.Time()
+}
+
+// Sequence number of a firehose event, or 0 if the event type has none.
+func streamEventSeq(xev *events.XRPCStreamEvent) int64 {
+	switch {
+	case xev.RepoCommit != nil:
+		return xev.RepoCommit.Seq
+	case xev.RepoSync != nil:
+		return xev.RepoSync.Seq
+	case xev.RepoIdentity != nil:
+		return xev.RepoIdentity.Seq
+	case xev.RepoAccount != nil:
+		return xev.RepoAccount.Seq
+	}
+	return 0
+}
+
+// Load the cursor from the store.
+func (l *FirehoseListener) loadStreamCursor(ctx context.Context) error {
+	value, err := l.store.Load(ctx, l.key)
+	if err != nil {
+		return fmt.Errorf("loadStreamCursor error (Load): %v", err)
+	}
+	if value == "" {
+		return nil
+	}
+	cursor, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("loadStreamCursor error (ParseInt): %v", err)
+	}
+	l.seqs.reset(cursor)
+	return nil
+}
+
+// Persist the cursor, if it wasn't persisted within the checkpoint interval.
+func (l *FirehoseListener) maybeSaveCursor(ctx context.Context) error {
+	l.mutex.Lock()
+	due := time.Since(l.savedAt) >= firehoseCheckpointInterval
+	if due {
+		l.savedAt = time.Now()
+	}
+	l.mutex.Unlock()
+	if !due {
+		return nil
+	}
+	return l.saveStreamCursor(context.WithoutCancel(ctx))
+}
+
+// Persist the cursor.
+func (l *FirehoseListener) saveStreamCursor(ctx context.Context) error {
+	cursor := l.seqs.watermark()
+	if cursor == 0 {
+		return nil
+	}
+	if err := l.store.Save(ctx, l.key, strconv.FormatInt(cursor, 10)); err != nil {
+		return fmt.Errorf("saveStreamCursor error (Save): %v", err)
+	}
+	return nil
+}
+
+// Scheduler registering the seq of every event before it is queued, so the cursor never skips unhandled events.
+//
+// The parallel scheduler queues events of busy repos without limit, so the number of queued events is bounded here:
+// AddWork blocks while the queue is full, which pauses reading from the connection.
+type trackingScheduler struct {
+	*parallel.Scheduler
+	seqs  *seqTracker
+	queue chan struct{} // one slot per event that has been added but not handled yet
+}
+
+func (s *trackingScheduler) AddWork(ctx context.Context, repo string, xev *events.XRPCStreamEvent) error {
+	select {
+	case s.queue <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	seq := streamEventSeq(xev)
+	if seq > 0 {
+		s.seqs.start(seq)
+	}
+	if err := s.Scheduler.AddWork(ctx, repo, xev); err != nil {
+		// the event won't be handled, so it must not hold back the cursor (nor be skipped by it)
+		if seq > 0 {
+			s.seqs.cancel(seq)
+		}
+		<-s.queue
+		return err
+	}
+	return nil
+}
+
+// Tracks the seqs of events in flight, to compute the newest seq up to which all events have been handled.
+type seqTracker struct {
+	pending map[int64]struct{}
+	newest  int64 // newest seq that was started
+	mutex   sync.Mutex
+}
+
+// Start tracking at the given cursor, forgetting all pending events.
+func (t *seqTracker) reset(cursor int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	clear(t.pending)
+	t.newest = cursor
+}
+
+func (t *seqTracker) start(seq int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.pending[seq] = struct{}{}
+	t.newest = max(t.newest, seq)
+}
+
+func (t *seqTracker) finish(seq int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.pending, seq)
+}
+
+// Stop tracking an event that was started but won't be handled. As events are started in order, the tracker then
+// continues as if the event had never been started.
+func (t *seqTracker) cancel(seq int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.pending, seq)
+	if t.newest == seq {
+		t.newest = seq - 1
+	}
+}
+
+// Newest seq such that all events up to it have been handled.
+func (t *seqTracker) watermark() int64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.pending) == 0 {
+		return t.newest
+	}
+	oldest := t.newest
+	for seq := range t.pending {
+		oldest = min(oldest, seq)
+	}
+	return oldest - 1
+}
@@ -0,0 +1,123 @@
+package listeners
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/ipfs/go-cid"
+	car "github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+)
+
+func TestSeqTrackerWatermark(t *testing.T) {
+	s := seqTracker{pending: make(map[int64]struct{})}
+	s.reset(10)
+	if w := s.watermark(); w != 10 {
+		t.Fatalf("watermark %d, want 10", w)
+	}
+
+	s.start(11)
+	s.start(12)
+	s.start(13)
+	// events of different repos finish out of order
+	s.finish(12)
+	if w := s.watermark(); w != 10 {
+		t.Fatalf("watermark %d passed the unfinished event 11", w)
+	}
+	s.finish(11)
+	if w := s.watermark(); w != 12 {
+		t.Fatalf("watermark %d, want 12", w)
+	}
+	s.finish(13)
+	if w := s.watermark(); w != 13 {
+		t.Fatalf("watermark %d, want 13", w)
+	}
+
+	// an event that couldn't be queued neither blocks nor advances the watermark
+	s.start(14)
+	s.cancel(14)
+	if w := s.watermark(); w != 13 {
+		t.Fatalf("watermark %d, want 13 after cancel", w)
+	}
+
+	s.start(15)
+	s.reset(s.watermark())
+	if w := s.watermark(); w != 14 {
+		t.Fatalf("watermark %d, want 14 after reset", w)
+	}
+}
+
+// Build the blocks CAR of a commit containing the given records.
+func testCommitBlocks(t *testing.T, records ...[]byte) ([]byte, []cid.Cid) {
+	t.Helper()
+	prefix := cid.Prefix{Version: 1, Codec: cid.DagCBOR, MhType: 0x12, MhLength: -1} // sha2-256
+	var cids []cid.Cid
+	for _, record := range records {
+		c, err := prefix.Sum(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cids = append(cids, c)
+	}
+	buf := new(bytes.Buffer)
+	if err := car.WriteHeader(&car.CarHeader{Roots: cids[:1], Version: 1}, buf); err != nil {
+		t.Fatal(err)
+	}
+	for i, record := range records {
+		if err := carutil.LdWrite(buf, cids[i].Bytes(), record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes(), cids
+}
+
+func TestDecodeCommit(t *testing.T) {
+	post := new(bytes.Buffer)
+	if err := (&bsky.FeedPost{LexiconTypeID: "app.bsky.feed.post", Text: "hello", CreatedAt: "2025-01-01T00:00:00Z"}).MarshalCBOR(post); err != nil {
+		t.Fatal(err)
+	}
+	// a record of a lexicon unknown to indigo
+	custom := []byte{0xa1, 0x65, '$', 't', 'y', 'p', 'e', 0x6a, 'c', 'o', 'm', '.', 'e', 'x', '.', 'f', 'o', 'o'}
+	blocks, cids := testCommitBlocks(t, post.Bytes(), custom)
+
+	commit := &atproto.SyncSubscribeRepos_Commit{
+		Repo:   "did:plc:author",
+		Rev:    "rev",
+		Time:   "2025-01-01T00:00:00Z",
+		Blocks: blocks,
+		Ops: []*atproto.SyncSubscribeRepos_RepoOp{
+			{Action: OpCreate, Path: "app.bsky.feed.post/1", Cid: (*lexutil.LexLink)(&cids[0])},
+			{Action: OpCreate, Path: "com.ex.foo/2", Cid: (*lexutil.LexLink)(&cids[1])},
+			{Action: OpDelete, Path: "app.bsky.feed.like/3"},
+			{Action: OpCreate, Path: "app.bsky.graph.follow/4", Cid: (*lexutil.LexLink)(&cids[0])},
+		},
+	}
+	events, err := decodeCommit(commit, func(collection string) bool { return collection != "app.bsky.graph.follow" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3 (the unwanted collection is filtered)", len(events))
+	}
+
+	created := events[0]
+	if created.Uri() != "at://did:plc:author/app.bsky.feed.post/1" || created.Commit.Cid != cids[0].String() || created.Commit.Rev != "rev" {
+		t.Fatalf("unexpected event %+v", created.Commit)
+	}
+	if record, ok := created.Commit.Record.(*bsky.FeedPost); !ok || record.Text != "hello" {
+		t.Fatalf("unexpected record %#v", created.Commit.Record)
+	}
+
+	unknown := events[1]
+	if unknown.Commit.Record != nil || !bytes.Equal(unknown.Commit.Raw, custom) {
+		t.Fatalf("unknown lexicon not passed on raw: %+v", unknown.Commit)
+	}
+
+	deleted := events[2]
+	if deleted.Commit.Operation != OpDelete || deleted.Commit.Cid != "" || deleted.Commit.Raw != nil {
+		t.Fatalf("unexpected delete %+v", deleted.Commit)
+	}
+}
@@ -0,0 +1,291 @@
+package listeners
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/davhofer/botsky/pkg/botsky"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Generic event handler class for the listener.
+//
+// Returned errors are reported through the Errors() channel of the listener.
+type Handler[EventT any] func(context.Context, *botsky.Client, []*EventT) error
+
+// Size of the buffer of the Errors() channel. Further errors are printed and dropped while it is full.
+const errorBufferSize = 64
+
+// Error of a listener, either of one of its handlers or of the listener itself (e.g. polling or connection errors).
+type ListenerError struct {
+	Listener  string
+	HandlerId string // empty for errors of the listener itself
+	Err       error
+}
+
+func (e *ListenerError) Error() string {
+	if e.HandlerId == "" {
+		return fmt.Sprintf("%s: %v", e.Listener, e.Err)
+	}
+	return fmt.Sprintf("%s: handler %s: %v", e.Listener, e.HandlerId, e.Err)
+}
+
+func (e *ListenerError) Unwrap() error {
+	return e.Err
+}
+
+// Error of a handler for a single one of the events it was passed. Return several of them with errors.Join to report
+// which events failed; only these are delivered to the handler again. Any other error fails all events of the call.
+type EventError struct {
+	Index int // index of the event in the list passed to the handler
+	Err   error
+}
+
+func (e *EventError) Error() string {
+	return fmt.Sprintf("event %d: %v", e.Index, e.Err)
+}
+
+func (e *EventError) Unwrap() error {
+	return e.Err
+}
+
+type handlerIdKey struct{}
+
+// Get the id of the handler the context was passed to, or an empty string if it wasn't passed to a handler.
+func HandlerIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(handlerIdKey{}).(string)
+	return id
+}
+
+// Set of registered event handlers and the settings for running them, shared by all listener types.
+type handlerRegistry[EventT any] struct {
+	handlers   map[string]Handler[EventT] // guarded by mutex
+	order      []string                   // handler ids in order of registration
+	name       string
+	timeout    time.Duration
+	sequential bool
+	semaphore  chan struct{} // limits the number of concurrently running handlers, nil if unlimited
+	errs       chan error
+	mutex      sync.Mutex
+}
+
+func newHandlerRegistry[EventT any](name string) handlerRegistry[EventT] {
+	return handlerRegistry[EventT]{
+		handlers: make(map[string]Handler[EventT]),
+		name:     name,
+		errs:     make(chan error, errorBufferSize),
+	}
+}
+
+// Try to register a new event handler. The id must be unique.
+//
+// Every registered event handler gets called on the full list of received events.
+func (r *handlerRegistry[EventT]) RegisterHandler(id string, handler Handler[EventT]) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, exists := r.handlers[id]; exists {
+		return fmt.Errorf("Handler with id %s already exists.", id)
+	}
+	r.handlers[id] = handler
+	r.order = append(r.order, id)
+	return nil
+}
+
+// Deregister (i.e. deactivate) a registered event handler.
+func (r *handlerRegistry[EventT]) DeregisterHandler(id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, exists := r.handlers[id]; !exists {
+		return fmt.Errorf("Handler with id %s is not registered.", id)
+	}
+	delete(r.handlers, id)
+	r.order = slices.DeleteFunc(r.order, func(other string) bool { return other == id })
+	return nil
+}
+
+// Ids of the registered event handlers, in order of registration.
+func (r *handlerRegistry[EventT]) HandlerIds() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return slices.Clone(r.order)
+}
+
+// Set a timeout for every handler call. Set to 0 for no timeout.
+//
+// The context passed to the handler is cancelled once the timeout expires, so the handler must respect it.
+func (r *handlerRegistry[EventT]) SetHandlerTimeout(timeout time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.timeout = timeout
+}
+
+// Limit how many handler calls can run at the same time (across all events). Set to 0 for no limit.
+func (r *handlerRegistry[EventT]) SetMaxConcurrency(n int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if n <= 0 {
+		r.semaphore = nil
+		return
+	}
+	r.semaphore = make(chan struct{}, n)
+}
+
+// Run the handlers one after another, in order of registration, instead of concurrently.
+func (r *handlerRegistry[EventT]) SetSequential(sequential bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sequential = sequential
+}
+
+// Channel receiving the errors returned by handlers, handler panics, and errors of the listener itself.
+// All errors are of type *ListenerError.
+//
+// The channel is buffered. If it is full (i.e. nobody is reading from it), further errors are printed and dropped.
+func (r *handlerRegistry[EventT]) Errors() <-chan error {
+	return r.errs
+}
+
+// Report an error through the Errors() channel.
+func (r *handlerRegistry[EventT]) reportError(handlerId string, err error) {
+	listenerErr := &ListenerError{Listener: r.name, HandlerId: handlerId, Err: err}
+	select {
+	case r.errs <- listenerErr:
+	default:
+		fmt.Println(listenerErr)
+	}
+}
+
+// Errors of a dispatch per handler id, with one entry per event (nil if the handler succeeded or skipped it).
+type dispatchResult map[string][]error
+
+// Pass the events to all registered handlers. Returns once all of them are done.
+//
+// Events for which skip returns true are not passed to that handler (e.g. because it already handled them). skip may be
+// nil. An event fails for a handler if the handler returned an error for it, panicked, timed out or was not run because
+// ctx was cancelled.
+func (r *handlerRegistry[EventT]) dispatch(ctx context.Context, client *botsky.Client, events []*EventT, skip func(id string, event int) bool) dispatchResult {
+	r.mutex.Lock()
+	ids := slices.Clone(r.order)
+	handlers := make([]Handler[EventT], len(ids))
+	for i, id := range ids {
+		handlers[i] = r.handlers[id]
+	}
+	timeout, sequential, semaphore := r.timeout, r.sequential, r.semaphore
+	r.mutex.Unlock()
+
+	result := make(dispatchResult, len(ids))
+	for _, id := range ids {
+		result[id] = make([]error, len(events))
+	}
+
+	run := func(id string, handler Handler[EventT]) {
+		var indexes []int
+		var subset []*EventT
+		for i, event := range events {
+			if skip == nil || !skip(id, i) {
+				indexes = append(indexes, i)
+				subset = append(subset, event)
+			}
+		}
+		if len(subset) == 0 {
+			return
+		}
+
+		if semaphore != nil {
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+				for _, i := range indexes {
+					result[id][i] = ctx.Err()
+				}
+				return
+			}
+		}
+		if err := runHandler(ctx, id, handler, timeout, client, subset); err != nil {
+			r.reportError(id, err)
+			for j, eventErr := range splitEventErrors(err, len(subset)) {
+				result[id][indexes[j]] = eventErr
+			}
+		}
+	}
+
+	if sequential {
+		for i, id := range ids {
+			run(id, handlers[i])
+		}
+		return result
+	}
+
+	// every handler only writes its own entry of the result
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run(id, handlers[i])
+		}()
+	}
+	wg.Wait()
+	return result
+}
+
+// Split the error of a handler call with n events into the errors per event.
+//
+// If the error consists of EventErrors only, just the events they name fail. Otherwise all events fail.
+func splitEventErrors(err error, n int) []error {
+	errs := make([]error, n)
+	var walk func(err error) bool
+	walk = func(err error) bool {
+		if eventErr, ok := err.(*EventError); ok {
+			if eventErr.Index < 0 || eventErr.Index >= n {
+				return false
+			}
+			errs[eventErr.Index] = errors.Join(errs[eventErr.Index], eventErr.Err)
+			return true
+		}
+		joined, ok := err.(interface{ Unwrap() []error })
+		if !ok {
+			return false
+		}
+		for _, err := range joined.Unwrap() {
+			if !walk(err) {
+				return false
+			}
+		}
+		return true
+	}
+	if !walk(err) {
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+// Call the handler with a timeout, recovering from panics.
+func runHandler[EventT any](ctx context.Context, id string, handler Handler[EventT], timeout time.Duration, client *botsky.Client, events []*EventT) (err error) {
+	// pass in the associated id with the context
+	ctx = context.WithValue(ctx, handlerIdKey{}, id)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v\n%s", p, debug.Stack())
+		}
+	}()
+	if err := handler(ctx, client, events); err != nil {
+		return err
+	}
+	if timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		// the handler ignored the cancellation, so it may not have finished its work
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+	return nil
+}
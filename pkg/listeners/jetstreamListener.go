@@ -0,0 +1,242 @@
+package listeners
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/davhofer/botsky/pkg/botsky"
+	"net/url"
+	"strconv"
+	"time"
+
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Default public Jetstream instance.
+const DefaultJetstreamEndpoint = "wss://jetstream2.us-east.bsky.network/subscribe"
+
+// How often the cursor is persisted while events are coming in.
+const jetstreamCheckpointInterval = time.Second
+
+// Options for a JetstreamListener.
+type JetstreamOptions struct {
+	Endpoint          string   // websocket url of the subscribe endpoint, defaults to DefaultJetstreamEndpoint
+	WantedCollections []string // only receive commits to these collections (NSIDs, prefixes like "app.bsky.graph.*" are allowed)
+	WantedDids        []string // only receive events of these repos
+	// Request zstd compressed messages. Jetstream compresses with a custom dictionary, which must be provided in
+	// ZstdDictionary (it is published in the Jetstream repository as pkg/models/zstd_dictionary).
+	Compress       bool
+	ZstdDictionary []byte
+}
+
+// Listener receiving events in real time from a Jetstream instance (a JSON-over-websocket view of the firehose).
+//
+// Every event is passed to the handlers as soon as it arrives. Handlers failing on an event get it again with
+// exponential backoff, until they succeed or the event is given up on (see SetMaxAttempts and DeadLetters); the
+// stream pauses meanwhile. If the connection drops, the listener reconnects and resumes after the last handled event.
+// The position is persisted in a CheckpointStore, so it also resumes after a restart.
+type JetstreamListener struct {
+	streamListener
+	opts    JetstreamOptions
+	store   CheckpointStore
+	key     string
+	cursor  int64     // time_us of the last handled event
+	savedAt time.Time // when the cursor was last persisted
+	decoder *zstd.Decoder
+}
+
+// Returns a set up JetstreamListener.
+//
+// The cursor is persisted in the given store. If store is nil, it is only kept in memory; on the first start the
+// listener then starts with live events.
+func NewJetstreamListener(client *botsky.Client, opts JetstreamOptions, store CheckpointStore) (*JetstreamListener, error) {
+	if opts.Endpoint == "" {
+		opts.Endpoint = DefaultJetstreamEndpoint
+	}
+	if store == nil {
+		store = NewMemoryCheckpointStore()
+	}
+
+	l := &JetstreamListener{
+		streamListener: newStreamListener(client, "JetstreamListener"),
+		opts:           opts,
+		store:          store,
+		key:            "jetstream:" + opts.Endpoint,
+	}
+	l.consume = l.consumeStream
+	l.loadCursor = l.loadStreamCursor
+	l.saveCursor = l.saveStreamCursor
+	if opts.Compress {
+		if len(opts.ZstdDictionary) == 0 {
+			return nil, fmt.Errorf("NewJetstreamListener error: Compress requires the Jetstream ZstdDictionary")
+		}
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderDicts(opts.ZstdDictionary))
+		if err != nil {
+			return nil, fmt.Errorf("NewJetstreamListener error (zstd.NewReader): %v", err)
+		}
+		l.decoder = decoder
+	}
+	return l, nil
+}
+
+// Connect to Jetstream and handle events until the connection fails or connCtx is cancelled.
+//
+// Reports whether a connection was established.
+func (l *JetstreamListener) consumeStream(connCtx context.Context, ctx context.Context) (bool, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(connCtx, l.subscribeUrl(), nil)
+	if err != nil {
+		return false, fmt.Errorf("consumeStream error (Dial): %v", err)
+	}
+	defer conn.Close()
+
+	// unblock ReadMessage when the listener is stopped
+	stop := context.AfterFunc(connCtx, func() { conn.Close() })
+	defer stop()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return true, fmt.Errorf("consumeStream error (ReadMessage): %v", err)
+		}
+		if l.decoder != nil {
+			if msg, err = l.decoder.DecodeAll(msg, nil); err != nil {
+				return true, fmt.Errorf("consumeStream error (DecodeAll): %v", err)
+			}
+		}
+
+		event, timeUs, err := parseJetstreamEvent(msg)
+		if err != nil {
+			l.reportError("", err)
+			continue
+		}
+		if event != nil && !l.deliver(connCtx, ctx, []*RepoEvent{event}) {
+			// stopped while retrying, the cursor stays before the event
+			return true, nil
+		}
+		l.cursor = max(l.cursor, timeUs)
+		if time.Since(l.savedAt) >= jetstreamCheckpointInterval {
+			if err := l.saveStreamCursor(ctx); err != nil {
+				l.reportError("", err)
+			}
+		}
+	}
+}
+
+// Url of the subscribe endpoint, including filters and the cursor to resume from.
+func (l *JetstreamListener) subscribeUrl() string {
+	params := url.Values{}
+	for _, collection := range l.opts.WantedCollections {
+		params.Add("wantedCollections", collection)
+	}
+	for _, did := range l.opts.WantedDids {
+		params.Add("wantedDids", did)
+	}
+	if l.opts.Compress {
+		params.Set("compress", "true")
+	}
+	if l.cursor > 0 {
+		// the cursor is inclusive, the last handled event must not be delivered again
+		params.Set("cursor", strconv.FormatInt(l.cursor+1, 10))
+	}
+	if len(params) == 0 {
+		return l.opts.Endpoint
+	}
+	return l.opts.Endpoint + "?" + params.Encode()
+}
+
+// Load the cursor from the store.
+func (l *JetstreamListener) loadStreamCursor(ctx context.Context) error {
+	value, err := l.store.Load(ctx, l.key)
+	if err != nil {
+		return fmt.Errorf("loadStreamCursor error (Load): %v", err)
+	}
+	if value == "" {
+		return nil
+	}
+	cursor, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("loadStreamCursor error (ParseInt): %v", err)
+	}
+	l.cursor = cursor
+	return nil
+}
+
+// Persist the cursor.
+func (l *JetstreamListener) saveStreamCursor(ctx context.Context) error {
+	if l.cursor == 0 {
+		return nil
+	}
+	if err := l.store.Save(ctx, l.key, strconv.FormatInt(l.cursor, 10)); err != nil {
+		return fmt.Errorf("saveStreamCursor error (Save): %v", err)
+	}
+	l.savedAt = time.Now()
+	return nil
+}
+
+// Jetstream wire format.
+type jetstreamMessage struct {
+	Did    string `json:"did"`
+	TimeUs int64  `json:"time_us"`
+	Kind   string `json:"kind"`
+	Commit *struct {
+		Rev        string          `json:"rev"`
+		Operation  string          `json:"operation"`
+		Collection string          `json:"collection"`
+		Rkey       string          `json:"rkey"`
+		Record     json.RawMessage `json:"record,omitempty"`
+		Cid        string          `json:"cid,omitempty"`
+	} `json:"commit,omitempty"`
+	Identity *struct {
+		Handle string `json:"handle,omitempty"`
+	} `json:"identity,omitempty"`
+	Account *struct {
+		Active bool   `json:"active"`
+		Status string `json:"status,omitempty"`
+	} `json:"account,omitempty"`
+}
+
+// Decode a Jetstream message into a RepoEvent.
+//
+// Returns the event (nil for unknown kinds) and its time_us, which is also returned if the event can't be decoded.
+func parseJetstreamEvent(msg []byte) (*RepoEvent, int64, error) {
+	var raw jetstreamMessage
+	if err := json.Unmarshal(msg, &raw); err != nil {
+		return nil, 0, fmt.Errorf("parseJetstreamEvent error (Unmarshal): %v", err)
+	}
+
+	event := &RepoEvent{
+		Kind: raw.Kind,
+		Did:  raw.Did,
+		Time: time.UnixMicro(raw.TimeUs),
+	}
+	switch {
+	case raw.Kind == RepoEventCommit && raw.Commit != nil:
+		event.Commit = &CommitOp{
+			Rev:        raw.Commit.Rev,
+			Operation:  raw.Commit.Operation,
+			Collection: raw.Commit.Collection,
+			Rkey:       raw.Commit.Rkey,
+			Cid:        raw.Commit.Cid,
+		}
+		if len(raw.Commit.Record) > 0 {
+			event.Commit.Raw = raw.Commit.Record
+			// custom lexicons can't be decoded, but are still passed on with their raw JSON
+			var record lexutil.LexiconTypeDecoder
+			if err := record.UnmarshalJSON(raw.Commit.Record); err == nil {
+				event.Commit.Record = record.Val
+			} else if !errors.Is(err, lexutil.ErrUnrecognizedType) {
+				return nil, raw.TimeUs, fmt.Errorf("parseJetstreamEvent error (UnmarshalJSON): %v", err)
+			}
+		}
+	case raw.Kind == RepoEventIdentity && raw.Identity != nil:
+		event.Identity = &IdentityChange{Handle: raw.Identity.Handle}
+	case raw.Kind == RepoEventAccount && raw.Account != nil:
+		event.Account = &AccountChange{Active: raw.Account.Active, Status: raw.Account.Status}
+	default:
+		return nil, raw.TimeUs, nil
+	}
+	return event, raw.TimeUs, nil
+}
@@ -0,0 +1,327 @@
+package listeners
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/davhofer/botsky/pkg/botsky"
+	"github.com/gorilla/websocket"
+)
+
+// A message the fake Jetstream sends.
+type jetstreamTestEvent struct {
+	did        string
+	timeUs     int64
+	collection string
+}
+
+func (e jetstreamTestEvent) json() []byte {
+	msg, _ := json.Marshal(map[string]any{
+		"did":     e.did,
+		"time_us": e.timeUs,
+		"kind":    "commit",
+		"commit": map[string]any{
+			"rev":        "rev",
+			"operation":  "create",
+			"collection": e.collection,
+			"rkey":       "rkey",
+			"cid":        "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm",
+			"record":     map[string]any{"$type": e.collection, "text": "hello", "createdAt": "2025-01-01T00:00:00Z", "subject": "did:plc:other"},
+		},
+	})
+	return msg
+}
+
+// Fake Jetstream instance. Every connection gets the events returned by script for the connection number (starting
+// at 0), filtered by the wantedCollections and wantedDids of the connection like Jetstream does.
+type fakeJetstream struct {
+	server  *httptest.Server
+	script  func(conn int, query url.Values) (events []jetstreamTestEvent, keepOpen bool)
+	mutex   sync.Mutex
+	queries []url.Values
+}
+
+func newFakeJetstream(t *testing.T, script func(conn int, query url.Values) ([]jetstreamTestEvent, bool)) *fakeJetstream {
+	t.Helper()
+	f := &fakeJetstream{script: script}
+	upgrader := websocket.Upgrader{}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		query := r.URL.Query()
+		f.mutex.Lock()
+		n := len(f.queries)
+		f.queries = append(f.queries, query)
+		f.mutex.Unlock()
+
+		events, keepOpen := f.script(n, query)
+		for _, event := range events {
+			if !jetstreamWants(query, event) {
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, event.json()); err != nil {
+				return
+			}
+		}
+		if keepOpen {
+			// until the client disconnects
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func jetstreamWants(query url.Values, event jetstreamTestEvent) bool {
+	if dids := query["wantedDids"]; len(dids) > 0 && !slices.Contains(dids, event.did) {
+		return false
+	}
+	collections := query["wantedCollections"]
+	if len(collections) == 0 {
+		return true
+	}
+	for _, wanted := range collections {
+		if prefix, ok := strings.CutSuffix(wanted, "*"); ok && strings.HasPrefix(event.collection, prefix) {
+			return true
+		}
+		if wanted == event.collection {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeJetstream) endpoint() string {
+	return "ws" + strings.TrimPrefix(f.server.URL, "http") + "/subscribe"
+}
+
+func (f *fakeJetstream) query(conn int) url.Values {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if conn >= len(f.queries) {
+		return nil
+	}
+	return f.queries[conn]
+}
+
+// Start the listener and collect the handled events.
+func startJetstreamListener(t *testing.T, l *JetstreamListener) <-chan *RepoEvent {
+	t.Helper()
+	received := make(chan *RepoEvent, 100)
+	err := l.RegisterHandler("collect", func(ctx context.Context, client *botsky.Client, events []*RepoEvent) error {
+		for _, event := range events {
+			received <- event
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if l.IsActive() {
+			l.Stop(context.Background())
+		}
+	})
+	return received
+}
+
+func receiveEvents(t *testing.T, received <-chan *RepoEvent, n int) []*RepoEvent {
+	t.Helper()
+	var events []*RepoEvent
+	timeout := time.After(10 * time.Second)
+	for len(events) < n {
+		select {
+		case event := <-received:
+			events = append(events, event)
+		case <-timeout:
+			t.Fatalf("received %d events, want %d", len(events), n)
+		}
+	}
+	return events
+}
+
+func TestJetstreamReconnectAndResume(t *testing.T) {
+	post := "app.bsky.feed.post"
+	fake := newFakeJetstream(t, func(conn int, query url.Values) ([]jetstreamTestEvent, bool) {
+		switch conn {
+		case 0:
+			// drop the connection after two events
+			return []jetstreamTestEvent{{"did:plc:a", 100, post}, {"did:plc:a", 200, post}}, false
+		case 1:
+			return []jetstreamTestEvent{{"did:plc:a", 300, post}}, true
+		}
+		return nil, true
+	})
+	store := NewMemoryCheckpointStore()
+
+	l, err := NewJetstreamListener(nil, JetstreamOptions{Endpoint: fake.endpoint()}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := startJetstreamListener(t, l)
+
+	events := receiveEvents(t, received, 3)
+	for i, event := range events {
+		if want := int64(i+1) * 100; event.Time.UnixMicro() != want {
+			t.Fatalf("event %d has time %d, want %d", i, event.Time.UnixMicro(), want)
+		}
+	}
+	if query := fake.query(0); query.Has("cursor") {
+		t.Fatalf("first connection sent cursor %s", query.Get("cursor"))
+	}
+	if cursor := fake.query(1).Get("cursor"); cursor != "201" {
+		t.Fatalf("reconnected with cursor %q, want 201", cursor)
+	}
+
+	if err := l.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := store.Load(context.Background(), "jetstream:"+fake.endpoint()); saved != "300" {
+		t.Fatalf("saved cursor %q, want 300", saved)
+	}
+
+	// a new listener with the same store resumes where the previous one stopped
+	restarted, err := NewJetstreamListener(nil, JetstreamOptions{Endpoint: fake.endpoint()}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startJetstreamListener(t, restarted)
+	deadline := time.Now().Add(10 * time.Second)
+	for fake.query(2) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("restarted listener didn't connect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cursor := fake.query(2).Get("cursor"); cursor != "301" {
+		t.Fatalf("restarted with cursor %q, want 301", cursor)
+	}
+}
+
+func TestJetstreamCollectionFilter(t *testing.T) {
+	fake := newFakeJetstream(t, func(conn int, query url.Values) ([]jetstreamTestEvent, bool) {
+		return []jetstreamTestEvent{
+			{"did:plc:a", 100, "app.bsky.feed.post"},
+			{"did:plc:a", 200, "app.bsky.feed.like"},
+			{"did:plc:a", 300, "app.bsky.graph.follow"},
+			{"did:plc:b", 400, "app.bsky.feed.post"},
+			{"did:plc:a", 500, "com.example.custom"},
+		}, true
+	})
+	opts := JetstreamOptions{
+		Endpoint:          fake.endpoint(),
+		WantedCollections: []string{"app.bsky.feed.post", "app.bsky.graph.*", "com.example.custom"},
+		WantedDids:        []string{"did:plc:a"},
+	}
+	l, err := NewJetstreamListener(nil, opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := startJetstreamListener(t, l)
+
+	events := receiveEvents(t, received, 3)
+	var collections []string
+	for _, event := range events {
+		collections = append(collections, event.Commit.Collection)
+	}
+	if want := []string{"app.bsky.feed.post", "app.bsky.graph.follow", "com.example.custom"}; !slices.Equal(collections, want) {
+		t.Fatalf("received %v, want %v", collections, want)
+	}
+
+	query := fake.query(0)
+	if !slices.Equal(query["wantedCollections"], opts.WantedCollections) || !slices.Equal(query["wantedDids"], opts.WantedDids) {
+		t.Fatalf("unexpected subscription %v", query)
+	}
+
+	if _, ok := events[0].Commit.Record.(*bsky.FeedPost); !ok {
+		t.Fatalf("post decoded as %T", events[0].Commit.Record)
+	}
+	if events[0].Uri() != "at://did:plc:a/app.bsky.feed.post/rkey" {
+		t.Fatalf("unexpected uri %s", events[0].Uri())
+	}
+	// custom lexicons are passed on undecoded
+	if events[2].Commit.Record != nil || len(events[2].Commit.Raw) == 0 {
+		t.Fatal("custom record not passed on raw")
+	}
+}
+
+func TestJetstreamRetriesFailedEvents(t *testing.T) {
+	post := "app.bsky.feed.post"
+	fake := newFakeJetstream(t, func(conn int, query url.Values) ([]jetstreamTestEvent, bool) {
+		return []jetstreamTestEvent{{"did:plc:a", 100, post}, {"did:plc:a", 200, post}}, true
+	})
+	l, err := NewJetstreamListener(nil, JetstreamOptions{Endpoint: fake.endpoint()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.retryDelay = time.Millisecond
+	l.SetMaxAttempts(2)
+
+	var mutex sync.Mutex
+	flakyCalls := make(map[int64]int)
+	l.RegisterHandler("flaky", func(ctx context.Context, client *botsky.Client, events []*RepoEvent) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		timeUs := events[0].Time.UnixMicro()
+		flakyCalls[timeUs]++
+		if timeUs == 100 && flakyCalls[timeUs] == 1 {
+			return errors.New("temporary failure")
+		}
+		if timeUs == 200 {
+			return errors.New("permanent failure")
+		}
+		return nil
+	})
+	received := startJetstreamListener(t, l)
+
+	events := receiveEvents(t, received, 2)
+	if events[0].Time.UnixMicro() != 100 || events[1].Time.UnixMicro() != 200 {
+		t.Fatalf("unexpected events %v, %v", events[0].Time, events[1].Time)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(l.DeadLetters()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("failing event not given up on")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := l.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the succeeding handler got every event once, the failing one until it succeeded or was given up on
+	select {
+	case event := <-received:
+		t.Fatalf("event %v delivered again to the succeeding handler", event.Time)
+	default:
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if flakyCalls[100] != 2 || flakyCalls[200] != 2 {
+		t.Fatalf("unexpected calls %v", flakyCalls)
+	}
+	if letter := l.DeadLetters()[0]; letter.Event.Time.UnixMicro() != 200 || letter.Attempts != 2 {
+		t.Fatalf("unexpected dead letter %+v", letter)
+	}
+}
@@ -0,0 +1,97 @@
+package listeners
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Start/stop state shared by all listener types.
+type lifecycle struct {
+	name  string
+	run   *listenerRun // nil while stopped
+	mutex sync.Mutex
+}
+
+// A single run of a listener, between Start and the exit of its loop.
+type listenerRun struct {
+	stop     chan struct{}      // closed to ask the loop to exit
+	stopping bool               // whether stop has been closed, guarded by the lifecycle mutex
+	cancel   context.CancelFunc // cancels the context of running handlers
+	done     chan struct{}      // closed once the loop and all handlers have returned
+}
+
+// Run the loop in a new goroutine until stop is called or ctx is cancelled.
+//
+// The loop must return once the stop channel is closed or its context is cancelled.
+func (lc *lifecycle) start(ctx context.Context, loop func(ctx context.Context, stop <-chan struct{})) error {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	if lc.run != nil {
+		if lc.run.stopping {
+			return fmt.Errorf("%s is still stopping", lc.name)
+		}
+		return fmt.Errorf("%s is already active", lc.name)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	run := &listenerRun{
+		stop:   make(chan struct{}),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	lc.run = run
+	go func() {
+		// the listener only counts as stopped once everything has returned, so a new run can't overlap with this one
+		defer lc.finish(run)
+		defer close(run.done)
+		defer cancel()
+		fmt.Println(lc.name, "started")
+		defer fmt.Println(lc.name, "stopped")
+		loop(ctx, run.stop)
+	}()
+	return nil
+}
+
+// Ask the loop to exit and wait until it and all running handlers have returned.
+//
+// If ctx is done before that, the context of the running handlers is cancelled and ctx.Err() is returned without
+// waiting any further. The listener stays active until the handlers have returned; call stop again to keep waiting.
+func (lc *lifecycle) stop(ctx context.Context) error {
+	lc.mutex.Lock()
+	run := lc.run
+	if run == nil {
+		lc.mutex.Unlock()
+		return fmt.Errorf("%s is already stopped", lc.name)
+	}
+	if !run.stopping {
+		run.stopping = true
+		close(run.stop)
+	}
+	lc.mutex.Unlock()
+
+	select {
+	case <-run.done:
+		lc.finish(run)
+		return nil
+	case <-ctx.Done():
+		run.cancel()
+		return fmt.Errorf("%s did not stop in time: %w", lc.name, ctx.Err())
+	}
+}
+
+// Mark the run as finished, once its loop and handlers have returned.
+func (lc *lifecycle) finish(run *listenerRun) {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	if lc.run == run {
+		lc.run = nil
+	}
+}
+
+// Whether the listener is currently running (or still stopping).
+func (lc *lifecycle) isActive() bool {
+	lc.mutex.Lock()
+	defer lc.mutex.Unlock()
+	return lc.run != nil
+}
@@ -0,0 +1,291 @@
+package listeners
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davhofer/botsky/pkg/botsky"
+)
+
+// Loop that runs until it is stopped or its context is cancelled, then waits for release (like draining handlers).
+func blockingLoop(release <-chan struct{}) func(context.Context, <-chan struct{}) {
+	return func(ctx context.Context, stop <-chan struct{}) {
+		select {
+		case <-stop:
+		case <-ctx.Done():
+		}
+		<-release
+	}
+}
+
+func waitInactive(t *testing.T, lc *lifecycle) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for lc.isActive() {
+		if time.Now().After(deadline) {
+			t.Fatal("lifecycle still active")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLifecycleStartStop(t *testing.T) {
+	lc := &lifecycle{name: "test"}
+	release := make(chan struct{})
+	close(release)
+
+	if err := lc.start(context.Background(), blockingLoop(release)); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if !lc.isActive() {
+		t.Fatal("not active after start")
+	}
+	if err := lc.start(context.Background(), blockingLoop(release)); err == nil {
+		t.Fatal("second start succeeded")
+	}
+	if err := lc.stop(context.Background()); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if lc.isActive() {
+		t.Fatal("active after stop")
+	}
+	if err := lc.stop(context.Background()); err == nil {
+		t.Fatal("second stop succeeded")
+	}
+
+	// can be restarted
+	if err := lc.start(context.Background(), blockingLoop(release)); err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	if err := lc.stop(context.Background()); err != nil {
+		t.Fatalf("stop after restart: %v", err)
+	}
+}
+
+func TestLifecycleContextCancelled(t *testing.T) {
+	lc := &lifecycle{name: "test"}
+	release := make(chan struct{})
+	close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := lc.start(ctx, blockingLoop(release)); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	cancel()
+	waitInactive(t, lc)
+
+	if err := lc.start(context.Background(), blockingLoop(release)); err != nil {
+		t.Fatalf("start after cancel: %v", err)
+	}
+	if err := lc.stop(context.Background()); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+}
+
+func TestLifecycleStopTimeout(t *testing.T) {
+	lc := &lifecycle{name: "test"}
+	release := make(chan struct{})
+
+	if err := lc.start(context.Background(), blockingLoop(release)); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := lc.stop(ctx); err == nil {
+		t.Fatal("stop didn't time out")
+	}
+
+	// the old run is still draining, so it must not be replaced
+	if !lc.isActive() {
+		t.Fatal("inactive while draining")
+	}
+	if err := lc.start(context.Background(), blockingLoop(release)); err == nil {
+		t.Fatal("start succeeded while draining")
+	}
+	// stopping again keeps waiting instead of panicking
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel2()
+	if err := lc.stop(ctx2); err == nil {
+		t.Fatal("second stop didn't time out")
+	}
+
+	close(release)
+	waitInactive(t, lc)
+	if err := lc.start(context.Background(), blockingLoop(release)); err != nil {
+		t.Fatalf("start after drain: %v", err)
+	}
+	if err := lc.stop(context.Background()); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+}
+
+func TestLifecycleConcurrent(t *testing.T) {
+	lc := &lifecycle{name: "test"}
+	release := make(chan struct{})
+	close(release)
+
+	var running, maxRunning int
+	var mutex sync.Mutex
+	loop := func(ctx context.Context, stop <-chan struct{}) {
+		mutex.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mutex.Unlock()
+		blockingLoop(release)(ctx, stop)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				lc.start(context.Background(), loop)
+				lc.isActive()
+				lc.stop(context.Background())
+			}
+		}()
+	}
+	wg.Wait()
+
+	waitInactive(t, lc)
+	if maxRunning > 1 {
+		t.Fatalf("%d runs overlapped", maxRunning)
+	}
+}
+
+// Listener polling a new event every millisecond.
+func newEndlessTestListener() *Listener[testEvent] {
+	var mutex sync.Mutex
+	n := 0
+	l := NewListener(nil, "test", func(ctx context.Context, client *botsky.Client) ([]*testEvent, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		n++
+		return []*testEvent{{strconv.Itoa(n)}}, nil
+	})
+	l.deliveryTracker.key = func(event *testEvent) string { return event.id }
+	l.PollingInterval = time.Millisecond
+	return l
+}
+
+func TestListenerRegisterHandlerWhileRunning(t *testing.T) {
+	l := newEndlessTestListener()
+	if err := l.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := &callCounter{calls: make(map[string]int)}
+	handler := func(ctx context.Context, client *botsky.Client, events []*testEvent) error {
+		calls.add(events)
+		return nil
+	}
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := "handler" + strconv.Itoa(i)
+			for range 50 {
+				if err := l.RegisterHandler(id, handler); err != nil {
+					t.Error(err)
+					return
+				}
+				l.HandlerIds()
+				l.SetHandlerTimeout(time.Second)
+				l.SetMaxConcurrency(2)
+				time.Sleep(100 * time.Microsecond)
+				if err := l.DeregisterHandler(id); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := l.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	calls.mutex.Lock()
+	defer calls.mutex.Unlock()
+	if len(calls.calls) == 0 {
+		t.Fatal("handlers were never called")
+	}
+}
+
+func TestListenerStopWaitsForHandlers(t *testing.T) {
+	l := newEndlessTestListener()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	var mutex sync.Mutex
+	running := 0
+	l.RegisterHandler("slow", func(ctx context.Context, client *botsky.Client, events []*testEvent) error {
+		mutex.Lock()
+		running++
+		mutex.Unlock()
+		once.Do(func() { close(started) })
+		<-release
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return nil
+	})
+	if err := l.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	stopped := make(chan error)
+	go func() { stopped <- l.Stop(context.Background()) }()
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned while a handler was running")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if running != 0 || l.IsActive() {
+		t.Fatalf("%d handlers still running after Stop", running)
+	}
+}
+
+func TestListenerStopDeadlineCancelsHandlers(t *testing.T) {
+	l := newEndlessTestListener()
+	started := make(chan struct{})
+	var once sync.Once
+	l.RegisterHandler("blocking", func(ctx context.Context, client *botsky.Client, events []*testEvent) error {
+		once.Do(func() { close(started) })
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := l.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Stop(ctx); err == nil {
+		t.Fatal("Stop didn't report the missed deadline")
+	}
+	// the cancelled handler returns, so the listener finishes
+	deadline := time.Now().Add(5 * time.Second)
+	for l.IsActive() {
+		if time.Now().After(deadline) {
+			t.Fatal("listener still active")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
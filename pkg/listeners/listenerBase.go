@@ -8,141 +8,131 @@ import (
 	"time"
 )
 
-// Generic event handler class for the listener.
-type Handler[EventT any] func(context.Context, *botsky.Client, []*EventT)
-
 // Generic event listener.
 type Listener[EventT any] struct {
+	handlerRegistry[EventT]
+	deliveryTracker[EventT]
 	Name            string
 	Client          *botsky.Client
-	ctx             context.Context
-	Active          bool
-	Handlers        map[string]Handler[EventT]
-	stopSignal      chan bool
-	PollingInterval time.Duration
+	PollingInterval time.Duration // use SetPollingInterval to change it while the listener is running
+	lifecycle       lifecycle
 	mutex           sync.Mutex
 	pollEventsFunc  func(context.Context, *botsky.Client) ([]*EventT, error) // gets called every PollingInterval seconds to get a list of events which will then be passed to the handlers
+	ackEventsFunc   func(context.Context, *botsky.Client, []*EventT) error   // optional, gets called with the events that all handlers have succeeded on (or that were given up on)
+	retryEventsFunc func([]*EventT)                                          // optional, gets called (before ackEventsFunc) with the events that failed in a handler, so they are polled again
 }
 
+// Maximum delay between polls while polling keeps failing.
+const maxPollBackoff = 5 * time.Minute
+
 // Creates a new listener. The pollEvents argument is a function that gets called in order to fetch the newest set of events to be handled.
-func NewListener[EventT any](ctx context.Context, client *botsky.Client, name string, pollEvents func(context.Context, *botsky.Client) ([]*EventT, error)) *Listener[EventT] {
+func NewListener[EventT any](client *botsky.Client, name string, pollEvents func(context.Context, *botsky.Client) ([]*EventT, error)) *Listener[EventT] {
 	if name == "" {
 		name = "Listener"
 	}
 	return &Listener[EventT]{
+		handlerRegistry: newHandlerRegistry[EventT](name),
+		deliveryTracker: newDeliveryTracker[EventT](nil),
 		Name:            name,
 		Client:          client,
-		ctx:             ctx,
-		Active:          false,
-		Handlers:        make(map[string]Handler[EventT]),
-		stopSignal:      make(chan bool, 1),
 		PollingInterval: time.Duration(time.Second * 5), // Default polling interval: 5s
+		lifecycle:       lifecycle{name: name},
 		pollEventsFunc:  pollEvents,
 	}
 }
 
-// Set how frequently the listener polls for new events.
+// Set how frequently the listener polls for new events. Takes effect after the next poll.
 func (l *Listener[EventT]) SetPollingInterval(seconds uint) {
 	// set to default
 	if seconds == 0 {
 		seconds = 5
 	}
-	restart := false
-	if l.Active {
-		l.Stop()
-		restart = true
-	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	l.PollingInterval = time.Duration(time.Duration(seconds) * time.Second)
-	if restart {
-		l.Start()
-	}
 }
 
-// Try to register a new event handler. The id must be unique.
+// Start listening (polling) in the background. This starts a new go routine.
 //
-// Every registered event handler gets called on the full list of polled events.
-func (l *Listener[EventT]) RegisterHandler(id string, handler Handler[EventT]) error {
-	if _, exists := l.Handlers[id]; exists {
-		return fmt.Errorf("Handler with id %s already exists.", id)
-	}
-	l.Handlers[id] = handler
-	return nil
-}
-
-// Deregister (i.e. deactivate) a registered event handler.
-func (l *Listener[EventT]) DeregisterHandler(id string) error {
-	if _, exists := l.Handlers[id]; !exists {
-		return fmt.Errorf("Handler with id %s is not registered.", id)
-	}
-	delete(l.Handlers, id)
-	return nil
+// The listener runs until Stop is called or ctx is cancelled. Handlers receive a context derived from ctx.
+func (l *Listener[EventT]) Start(ctx context.Context) error {
+	return l.lifecycle.start(ctx, l.listen)
 }
 
-// Start listening (polling) in the background. This starts a new go routine.
-func (l *Listener[EventT]) Start() {
-	if l.Active {
-		fmt.Println(l.Name, "is already active.")
-		return
-	}
-	l.Active = true
-	go l.listen()
+// Stop listening. Blocks until the polling loop has exited and all running handlers have returned.
+//
+// If ctx is done before that (e.g. a drain deadline passed), running handlers are cancelled and an error is returned.
+// The listener stays active (and can't be restarted) until they have returned.
+func (l *Listener[EventT]) Stop(ctx context.Context) error {
+	return l.lifecycle.stop(ctx)
 }
 
-// Stop listening.
-func (l *Listener[EventT]) Stop() {
-	if !l.Active {
-		fmt.Println(l.Name, "is already stopped.")
-		return
-	}
-	l.stopSignal <- true
-	l.Active = false
+// Whether the listener is currently running.
+func (l *Listener[EventT]) IsActive() bool {
+	return l.lifecycle.isActive()
 }
 
 // Continuous loop that listens and distributes polled events to handlers.
 // Is run as a goroutine.
-func (l *Listener[EventT]) listen() {
-	ticker := time.NewTicker(l.PollingInterval)
-	fmt.Println(l.Name, "started")
-	defer fmt.Println(l.Name, "stopped")
-	defer ticker.Stop()
-
+//
+// While polling fails, or handlers fail on some of the events, the delay between polls is doubled with every failure
+// (up to maxPollBackoff).
+func (l *Listener[EventT]) listen(ctx context.Context, stop <-chan struct{}) {
+	failures := 0
 	for {
+		l.mutex.Lock()
+		delay := l.PollingInterval
+		l.mutex.Unlock()
+		if failures > 0 {
+			delay = min(delay<<min(failures, 16), maxPollBackoff)
+		}
+
+		timer := time.NewTimer(delay)
 		select {
-		case <-l.stopSignal:
+		case <-stop:
+			timer.Stop()
+			return
+		case <-ctx.Done():
+			timer.Stop()
 			return
-		case <-ticker.C:
+		case <-timer.C:
+		}
 
-			events, err := l.pollEventsFunc(l.ctx, l.Client)
-			if err != nil {
-				// TODO: logging/error handling...
-				fmt.Println(l.Name, "pollAndHandle error:", err)
-				continue
-			}
+		events, err := l.pollEventsFunc(ctx, l.Client)
+		if err != nil {
+			failures++
+			l.reportError("", fmt.Errorf("poll error: %v", err))
+			continue
+		}
 
-			if len(events) == 0 {
-				continue
-			}
+		if len(events) == 0 {
+			failures = 0
+			continue
+		}
 
-			for id, handler := range l.Handlers {
-				// pass in the associated id with the context
-				go handler(context.WithValue(l.ctx, "id", id), l.Client, events)
-			}
+		// handlers only get the events they haven't handled yet in a previous attempt
+		result := l.dispatch(ctx, l.Client, events, func(id string, i int) bool {
+			return l.handled(events[i], id)
+		})
+		// failures caused by shutting down don't count as attempts
+		done, failed, dead := l.settle(events, result, ctx.Err() == nil)
+		for _, letter := range dead {
+			l.reportError("", fmt.Errorf("giving up on event after %d attempts: %v", letter.Attempts, letter.Err))
+		}
 
+		// events are only acknowledged once all handlers are done with them, failed ones are delivered again
+		if len(failed) > 0 {
+			failures++
+			if l.retryEventsFunc != nil {
+				l.retryEventsFunc(failed)
+			}
+		} else {
+			failures = 0
+		}
+		if len(done) > 0 && l.ackEventsFunc != nil {
+			if err := l.ackEventsFunc(ctx, l.Client, done); err != nil {
+				l.reportError("", fmt.Errorf("ack error: %v", err))
+			}
 		}
 	}
 }
-
-/*
-handler functions can be closures, to include e.g. pointers to containers for storing results, channels, the client, etc. to handlers
-
-user must take care of errors in handler, e.g. by logging
-
-TODO: cancellation/timeout of handlers?
-TODO: logging
-
-should we directly implement specific event handlers? e.g.
-OnMention() {}
-OnLike() {}
-OnReply() {}
-etc.
-*/
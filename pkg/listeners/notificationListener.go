@@ -4,43 +4,170 @@ import (
 	"context"
 	"fmt"
 	"github.com/davhofer/botsky/pkg/botsky"
-
-	"github.com/bluesky-social/indigo/api/bsky"
+	"slices"
+	"sync"
+	"time"
 )
 
 // Instantiation of the (polling) listenerBase for handling notifications.
+//
+// The listener remembers the newest IndexedAt it has handled (the checkpoint) and only marks notifications as seen up
+// to that point, once all handlers have returned. Notifications are thus delivered at least once: if the process dies
+// while handlers are running, they are delivered again after a restart (if the checkpoint store is persistent). If a
+// handler fails on a notification, the notification is delivered to that handler again with the next poll, and the
+// checkpoint doesn't move past it until it was handled or given up on (see SetMaxAttempts).
 type PollingNotificationListener struct {
-	Listener[bsky.NotificationListNotifications_Notification]
+	Listener[botsky.Notification]
+	checkpoint *notifCheckpoint
 }
 
 // Returns an set up PollingNotificationListener.
-func NewPollingNotificationListener(ctx context.Context, client *botsky.Client) *PollingNotificationListener {
-	return &PollingNotificationListener{*NewListener(ctx, client, "PollingNotificationListener", pollNotifications)}
+//
+// The checkpoint is persisted in the given store. If store is nil, it is only kept in memory; on the first start the
+// listener then delivers all unread notifications.
+func NewPollingNotificationListener(client *botsky.Client, store CheckpointStore) *PollingNotificationListener {
+	if store == nil {
+		store = NewMemoryCheckpointStore()
+	}
+	checkpoint := &notifCheckpoint{
+		store:     store,
+		key:       "notifications:" + client.Did,
+		delivered: make(map[string]time.Time),
+		retrying:  make(map[string]time.Time),
+	}
+	l := &PollingNotificationListener{*NewListener(client, "PollingNotificationListener", checkpoint.poll), checkpoint}
+	l.ackEventsFunc = checkpoint.ack
+	l.retryEventsFunc = checkpoint.retry
+	l.deliveryTracker.key = func(notif *botsky.Notification) string { return notif.Uri }
+	return l
+}
+
+// Tracks which notifications have been handled.
+type notifCheckpoint struct {
+	store     CheckpointStore
+	key       string
+	loaded    bool
+	newest    time.Time            // IndexedAt of the newest notification that has been fully handled
+	delivered map[string]time.Time // uri -> IndexedAt of notifications delivered at or after newest, for dedupe
+	retrying  map[string]time.Time // uri -> IndexedAt of notifications a handler failed on, the checkpoint stays before them
+	mutex     sync.Mutex
 }
 
-// Get all unread notifications (and set them to "read" afterwards).
-func pollNotifications(ctx context.Context, client *botsky.Client) ([]*bsky.NotificationListNotifications_Notification, error) {
-	count, err := client.NotifGetUnreadCount(ctx)
+// Get all notifications newer than the checkpoint, oldest first.
+func (n *notifCheckpoint) poll(ctx context.Context, client *botsky.Client) ([]*botsky.Notification, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if !n.loaded {
+		if err := n.load(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	opts := botsky.NotifOptions{}
+	if n.newest.IsZero() {
+		opts.UnreadOnly = true
+	} else {
+		// include notifications indexed at exactly the checkpoint, duplicates are filtered below
+		opts.Since = n.newest.Add(-time.Nanosecond)
+	}
+	notifications, err := client.NotifGetNotifications(ctx, -1, opts)
 	if err != nil {
 		return nil, err
 	}
-	if count == 0 {
-		return []*bsky.NotificationListNotifications_Notification{}, nil
+
+	var fresh []*botsky.Notification
+	for _, notif := range notifications {
+		if _, ok := n.delivered[notif.Uri]; ok {
+			continue
+		}
+		n.delivered[notif.Uri] = notif.IndexedAt
+		fresh = append(fresh, notif)
+	}
+	if len(fresh) > 0 {
+		fmt.Println("listener:", len(fresh), "new notifications")
 	}
 
-	fmt.Println("listener:", count, "new notifications")
+	// deliver in chronological order
+	slices.Reverse(fresh)
+	return fresh, nil
+}
+
+// Advance the checkpoint past the handled notifications, persist it, and mark them as seen on the server.
+//
+// The checkpoint never moves past a notification that is still being retried. If saving fails, it is saved with the next
+// ack; the handled notifications are remembered in the meantime, so they aren't delivered again.
+func (n *notifCheckpoint) ack(ctx context.Context, client *botsky.Client, notifications []*botsky.Notification) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
 
-	notifications, err := client.NotifGetNotifications(ctx, count)
-	if err != nil {
-		return nil, err
+	for _, notif := range notifications {
+		delete(n.retrying, notif.Uri)
+	}
+	var oldestRetrying time.Time
+	for _, indexedAt := range n.retrying {
+		if oldestRetrying.IsZero() || indexedAt.Before(oldestRetrying) {
+			oldestRetrying = indexedAt
+		}
 	}
 
-	// mark notifications as seen
-	if err := client.NotifUpdateSeen(ctx); err != nil {
-		return nil, err
+	// all delivered notifications are handled at this point, the failed ones were forgotten by retry
+	newest := n.newest
+	for _, indexedAt := range n.delivered {
+		if indexedAt.After(newest) && (oldestRetrying.IsZero() || indexedAt.Before(oldestRetrying)) {
+			newest = indexedAt
+		}
+	}
+	if !newest.After(n.newest) {
+		return nil
+	}
+
+	if err := n.store.Save(ctx, n.key, newest.UTC().Format(time.RFC3339Nano)); err != nil {
+		return fmt.Errorf("ack error (Save): %v", err)
+	}
+	n.newest = newest
+	for uri, indexedAt := range n.delivered {
+		if indexedAt.Before(newest) {
+			delete(n.delivered, uri)
+		}
+	}
+
+	if err := client.NotifUpdateSeen(ctx, newest); err != nil {
+		return fmt.Errorf("ack error (NotifUpdateSeen): %v", err)
+	}
+	return nil
+}
+
+// Forget that the notifications were delivered after a handler failed, so they are delivered again with the next poll.
+func (n *notifCheckpoint) retry(notifications []*botsky.Notification) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.forget(notifications)
+	for _, notif := range notifications {
+		n.retrying[notif.Uri] = notif.IndexedAt
 	}
+}
 
-	return notifications, nil
+// Load the checkpoint from the store.
+func (n *notifCheckpoint) load(ctx context.Context) error {
+	value, err := n.store.Load(ctx, n.key)
+	if err != nil {
+		return fmt.Errorf("load error (Load): %v", err)
+	}
+	if value != "" {
+		newest, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return fmt.Errorf("load error (Parse): %v", err)
+		}
+		n.newest = newest
+	}
+	n.loaded = true
+	return nil
 }
 
-// NOTE for handlers: ReasonSubject is used by replies and likes, to indicate which of the bots posts it was directed towards
+// Forget that the notifications were delivered, so they are delivered again.
+func (n *notifCheckpoint) forget(notifications []*botsky.Notification) {
+	for _, notif := range notifications {
+		delete(n.delivered, notif.Uri)
+	}
+}
@@ -0,0 +1,186 @@
+package listeners
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/davhofer/botsky/pkg/botsky"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+// Handler for a single, typed event.
+type EventHandler[E any] func(context.Context, *botsky.Client, E) error
+
+// Predicate deciding whether a notification gets passed to a handler.
+type NotifFilter func(*botsky.Notification) bool
+
+// A mention, reply or quote of the bot.
+type PostEvent struct {
+	*botsky.Notification
+	Post *bsky.FeedPost // the mentioning/replying/quoting post
+}
+
+// A like or repost of one of the bots records.
+type SubjectEvent struct {
+	*botsky.Notification
+	SubjectUri string // uri of the liked/reposted record
+}
+
+// A new follower of the bot.
+type FollowEvent struct {
+	*botsky.Notification
+	Follower *bsky.ActorDefs_ProfileView
+}
+
+// Routes the notifications of a PollingNotificationListener to handlers registered per reason.
+//
+// Handlers are called once per event (instead of once per batch), in chronological order.
+type NotificationRouter struct {
+	routes map[string][]func(context.Context, *botsky.Client, *botsky.Notification) error
+	mutex  sync.Mutex
+}
+
+// Returns a NotificationRouter registered as a handler of the given listener.
+func NewNotificationRouter(listener *PollingNotificationListener) (*NotificationRouter, error) {
+	r := &NotificationRouter{
+		routes: make(map[string][]func(context.Context, *botsky.Client, *botsky.Notification) error),
+	}
+	if err := listener.RegisterHandler("notificationRouter", r.handle); err != nil {
+		return nil, fmt.Errorf("NewNotificationRouter error (RegisterHandler): %v", err)
+	}
+	return r, nil
+}
+
+// Call the handler for every mention of the bot that passes all filters.
+func (r *NotificationRouter) OnMention(handler EventHandler[*PostEvent], filters ...NotifFilter) {
+	addRoute(r, botsky.NotifReasonMention, newPostEvent, handler, filters)
+}
+
+// Call the handler for every reply to one of the bots posts that passes all filters.
+func (r *NotificationRouter) OnReply(handler EventHandler[*PostEvent], filters ...NotifFilter) {
+	addRoute(r, botsky.NotifReasonReply, newPostEvent, handler, filters)
+}
+
+// Call the handler for every quote of one of the bots posts that passes all filters.
+func (r *NotificationRouter) OnQuote(handler EventHandler[*PostEvent], filters ...NotifFilter) {
+	addRoute(r, botsky.NotifReasonQuote, newPostEvent, handler, filters)
+}
+
+// Call the handler for every like of one of the bots records that passes all filters.
+func (r *NotificationRouter) OnLike(handler EventHandler[*SubjectEvent], filters ...NotifFilter) {
+	addRoute(r, botsky.NotifReasonLike, newSubjectEvent, handler, filters)
+}
+
+// Call the handler for every repost of one of the bots posts that passes all filters.
+func (r *NotificationRouter) OnRepost(handler EventHandler[*SubjectEvent], filters ...NotifFilter) {
+	addRoute(r, botsky.NotifReasonRepost, newSubjectEvent, handler, filters)
+}
+
+// Call the handler for every new follower that passes all filters.
+func (r *NotificationRouter) OnFollow(handler EventHandler[*FollowEvent], filters ...NotifFilter) {
+	addRoute(r, botsky.NotifReasonFollow, newFollowEvent, handler, filters)
+}
+
+// Register a route for the given reason, converting notifications into events of type E.
+// (Not a method, since methods can't have type parameters.)
+func addRoute[E any](r *NotificationRouter, reason string, newEvent func(*botsky.Notification) (E, bool), handler EventHandler[E], filters []NotifFilter) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.routes[reason] = append(r.routes[reason], func(ctx context.Context, client *botsky.Client, notif *botsky.Notification) error {
+		for _, filter := range filters {
+			if !filter(notif) {
+				return nil
+			}
+		}
+		if event, ok := newEvent(notif); ok {
+			return handler(ctx, client, event)
+		}
+		return nil
+	})
+}
+
+// Listener handler dispatching every notification to the routes of its reason.
+//
+// A failing handler doesn't stop the other events from being handled. Errors are returned per event (as EventErrors), so
+// only the failed events are delivered again.
+func (r *NotificationRouter) handle(ctx context.Context, client *botsky.Client, notifications []*botsky.Notification) error {
+	r.mutex.Lock()
+	routes := make(map[string][]func(context.Context, *botsky.Client, *botsky.Notification) error, len(r.routes))
+	for reason, handlers := range r.routes {
+		routes[reason] = slices.Clone(handlers)
+	}
+	r.mutex.Unlock()
+
+	var errs []error
+	for i, notif := range notifications {
+		var notifErrs []error
+		for _, handler := range routes[notif.Reason] {
+			if err := handler(ctx, client, notif); err != nil {
+				notifErrs = append(notifErrs, fmt.Errorf("%s %s: %w", notif.Reason, notif.Uri, err))
+			}
+		}
+		if len(notifErrs) > 0 {
+			errs = append(errs, &EventError{Index: i, Err: errors.Join(notifErrs...)})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func newPostEvent(notif *botsky.Notification) (*PostEvent, bool) {
+	if notif.Post == nil {
+		return nil, false
+	}
+	return &PostEvent{Notification: notif, Post: notif.Post}, true
+}
+
+func newSubjectEvent(notif *botsky.Notification) (*SubjectEvent, bool) {
+	return &SubjectEvent{Notification: notif, SubjectUri: notif.Subject}, true
+}
+
+func newFollowEvent(notif *botsky.Notification) (*FollowEvent, bool) {
+	if notif.Author == nil {
+		return nil, false
+	}
+	return &FollowEvent{Notification: notif, Follower: notif.Author}, true
+}
+
+// Only pass notifications by one of the given authors (handles or DIDs).
+func FromAuthor(handlesOrDids ...string) NotifFilter {
+	return func(notif *botsky.Notification) bool {
+		if notif.Author == nil {
+			return false
+		}
+		return slices.Contains(handlesOrDids, notif.Author.Did) || slices.Contains(handlesOrDids, notif.Author.Handle)
+	}
+}
+
+// Only pass notifications whose post is in one of the given languages (e.g. "en"). Notifications without a post are
+// dropped.
+func WithLanguage(langs ...string) NotifFilter {
+	return func(notif *botsky.Notification) bool {
+		if notif.Post == nil {
+			return false
+		}
+		for _, lang := range notif.Post.Langs {
+			// also match regional variants, e.g. "en" matches "en-US"
+			base, _, _ := strings.Cut(lang, "-")
+			if slices.Contains(langs, lang) || slices.Contains(langs, base) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Only pass notifications whose post contains the given text (case-insensitive). Notifications without a post are
+// dropped.
+func ContainsText(text string) NotifFilter {
+	text = strings.ToLower(text)
+	return func(notif *botsky.Notification) bool {
+		return notif.Post != nil && strings.Contains(strings.ToLower(notif.Post.Text), text)
+	}
+}
@@ -0,0 +1,61 @@
+package listeners
+
+import (
+	"time"
+
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// Kinds of repo events.
+const (
+	RepoEventCommit   = "commit"
+	RepoEventIdentity = "identity"
+	RepoEventAccount  = "account"
+)
+
+// Operations of a commit.
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// An event from the network (e.g. via Jetstream): a record operation in a repo, or a change of an account.
+type RepoEvent struct {
+	Kind     string // one of the RepoEvent* constants
+	Did      string
+	Time     time.Time
+	Commit   *CommitOp       // set for commit events
+	Identity *IdentityChange // set for identity events
+	Account  *AccountChange  // set for account events
+}
+
+// A single record operation (create, update or delete) in a repo.
+type CommitOp struct {
+	Rev        string
+	Operation  string // one of the Op* constants
+	Collection string
+	Rkey       string
+	Cid        string            // empty for deletes
+	Record     cbg.CBORMarshaler // decoded record, nil for deletes and if the lexicon is unknown to indigo (see Raw)
+	Raw        []byte            // raw encoding of the record, nil for deletes
+}
+
+// The handle (or DID document) of an account changed.
+type IdentityChange struct {
+	Handle string // may be empty
+}
+
+// The hosting status of an account changed (e.g. deactivated, takendown).
+type AccountChange struct {
+	Active bool
+	Status string // reason the account is inactive, empty if active
+}
+
+// Uri of the record affected by a commit event, or an empty string for other events.
+func (e *RepoEvent) Uri() string {
+	if e.Commit == nil {
+		return ""
+	}
+	return "at://" + e.Did + "/" + e.Commit.Collection + "/" + e.Commit.Rkey
+}
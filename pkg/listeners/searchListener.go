@@ -0,0 +1,274 @@
+package listeners
+
+import (
+	"context"
+	"fmt"
+	"github.com/davhofer/botsky/pkg/botsky"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+const (
+	// Maximum number of new posts fetched per query and poll. If more posts match in between two polls, the older
+	// ones are skipped.
+	maxSearchHitsPerPoll = 500
+	// Search filters by the time a post claims to be created at, which can be slightly before it was indexed. Search
+	// a bit further back than the checkpoint so such posts aren't missed; older posts are filtered by IndexedAt.
+	searchSinceMargin = 5 * time.Minute
+)
+
+// A saved search run by a PollingSearchListener.
+type SearchQuery struct {
+	Name    string               // identifies the query in hits and checkpoints, defaults to Query
+	Query   string               // search query, see Client.SearchPosts
+	Options botsky.SearchOptions // Sort and Since are set by the listener
+}
+
+// A new post matching one of the saved queries of a PollingSearchListener.
+type SearchHit struct {
+	Query     string // Name of the matching query
+	Post      *botsky.RichPost
+	IndexedAt time.Time
+}
+
+// Instantiation of the (polling) listenerBase for watching keywords, hashtags, etc. through post search.
+//
+// Every poll runs all saved queries and delivers the posts indexed after the newest post handled for that query (the
+// checkpoint). When a query is added, its checkpoint starts at that time, so only posts created afterwards are
+// delivered. A post matching multiple queries is delivered once per query.
+type PollingSearchListener struct {
+	Listener[SearchHit]
+	checkpoint *searchCheckpoint
+}
+
+// Returns an set up PollingSearchListener, polling every 30s.
+//
+// The checkpoints are persisted in the given store. If store is nil, they are only kept in memory.
+func NewPollingSearchListener(client *botsky.Client, store CheckpointStore, queries ...SearchQuery) *PollingSearchListener {
+	if store == nil {
+		store = NewMemoryCheckpointStore()
+	}
+	checkpoint := &searchCheckpoint{
+		store:  store,
+		prefix: "search:" + client.Did + ":",
+		states: make(map[string]*queryState),
+	}
+	for _, query := range queries {
+		checkpoint.add(query)
+	}
+	l := &PollingSearchListener{*NewListener(client, "PollingSearchListener", checkpoint.poll), checkpoint}
+	l.ackEventsFunc = checkpoint.ack
+	l.retryEventsFunc = checkpoint.retry
+	l.deliveryTracker.key = func(hit *SearchHit) string { return hit.Query + " " + hit.Post.Uri }
+	l.PollingInterval = 30 * time.Second
+	return l
+}
+
+// Add a saved query, replacing a query with the same name. Takes effect with the next poll.
+func (l *PollingSearchListener) AddQuery(query SearchQuery) {
+	l.checkpoint.mutex.Lock()
+	defer l.checkpoint.mutex.Unlock()
+	l.checkpoint.add(query)
+}
+
+// Remove the saved query with the given name. Its checkpoint stays in the store, so re-adding it later resumes there.
+func (l *PollingSearchListener) RemoveQuery(name string) {
+	l.checkpoint.mutex.Lock()
+	defer l.checkpoint.mutex.Unlock()
+	delete(l.checkpoint.states, name)
+}
+
+// Names of the saved queries.
+func (l *PollingSearchListener) Queries() []string {
+	l.checkpoint.mutex.Lock()
+	defer l.checkpoint.mutex.Unlock()
+	names := make([]string, 0, len(l.checkpoint.states))
+	for name := range l.checkpoint.states {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Tracks which posts have been handled, per saved query.
+type searchCheckpoint struct {
+	store  CheckpointStore
+	prefix string
+	states map[string]*queryState // query name -> state
+	mutex  sync.Mutex
+}
+
+type queryState struct {
+	query     SearchQuery
+	loaded    bool
+	newest    time.Time            // IndexedAt of the newest post that has been fully handled
+	delivered map[string]time.Time // uri -> IndexedAt of posts delivered at or after newest, for dedupe
+	retrying  map[string]time.Time // uri -> IndexedAt of posts a handler failed on, the checkpoint stays before them
+}
+
+// Add a query. Must be called with the mutex held.
+func (s *searchCheckpoint) add(query SearchQuery) {
+	if query.Name == "" {
+		query.Name = query.Query
+	}
+	s.states[query.Name] = &queryState{query: query, delivered: make(map[string]time.Time), retrying: make(map[string]time.Time)}
+}
+
+// Run all saved queries and get the posts newer than their checkpoints, oldest first.
+//
+// The posts are only marked as delivered once all queries succeeded, so a failing query doesn't lose the hits of the
+// others.
+func (s *searchCheckpoint) poll(ctx context.Context, client *botsky.Client) ([]*SearchHit, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var hits []*SearchHit
+	for name, state := range s.states {
+		if !state.loaded {
+			if err := s.load(ctx, state); err != nil {
+				return nil, err
+			}
+		}
+
+		opts := state.query.Options
+		opts.Sort = botsky.SearchSortLatest
+		opts.Since = state.newest.Add(-searchSinceMargin)
+		posts, err := client.SearchPosts(ctx, state.query.Query, opts, maxSearchHitsPerPoll)
+		if err != nil {
+			return nil, fmt.Errorf("poll error (query %q): %v", name, err)
+		}
+
+		// include posts indexed at exactly the checkpoint, duplicates are filtered by uri
+		since := state.newest.Add(-time.Nanosecond)
+		seen := make(map[string]bool)
+		for _, post := range posts {
+			datetime, err := syntax.ParseDatetimeLenient(post.IndexedAt)
+			if err != nil {
+				fmt.Println("listener: skipping search hit", post.Uri, "with invalid indexedAt:", err)
+				continue
+			}
+			indexedAt := datetime.Time()
+			if !indexedAt.After(since) {
+				continue
+			}
+			if _, ok := state.delivered[post.Uri]; ok || seen[post.Uri] {
+				continue
+			}
+			seen[post.Uri] = true
+			hits = append(hits, &SearchHit{Query: name, Post: post, IndexedAt: indexedAt})
+		}
+	}
+
+	for _, hit := range hits {
+		s.states[hit.Query].delivered[hit.Post.Uri] = hit.IndexedAt
+	}
+	if len(hits) > 0 {
+		fmt.Println("listener:", len(hits), "new search hits")
+	}
+
+	// deliver in chronological order
+	slices.SortStableFunc(hits, func(a, b *SearchHit) int {
+		return a.IndexedAt.Compare(b.IndexedAt)
+	})
+	return hits, nil
+}
+
+// Advance the checkpoints of the queries past the handled posts and persist them.
+//
+// A checkpoint never moves past a post that is still being retried. If saving fails, it is saved with the next ack; the
+// handled posts are remembered in the meantime, so they aren't delivered again.
+func (s *searchCheckpoint) ack(ctx context.Context, client *botsky.Client, hits []*SearchHit) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	queries := make(map[string]bool)
+	for _, hit := range hits {
+		queries[hit.Query] = true
+		if state, ok := s.states[hit.Query]; ok {
+			delete(state.retrying, hit.Post.Uri)
+		}
+	}
+
+	for name := range queries {
+		state, ok := s.states[name]
+		if !ok {
+			// the query was removed in the meantime
+			continue
+		}
+		var oldestRetrying time.Time
+		for _, indexedAt := range state.retrying {
+			if oldestRetrying.IsZero() || indexedAt.Before(oldestRetrying) {
+				oldestRetrying = indexedAt
+			}
+		}
+
+		// all delivered posts are handled at this point, the failed ones were forgotten by retry
+		newest := state.newest
+		for _, indexedAt := range state.delivered {
+			if indexedAt.After(newest) && (oldestRetrying.IsZero() || indexedAt.Before(oldestRetrying)) {
+				newest = indexedAt
+			}
+		}
+		if !newest.After(state.newest) {
+			continue
+		}
+		if err := s.store.Save(ctx, s.prefix+name, newest.UTC().Format(time.RFC3339Nano)); err != nil {
+			return fmt.Errorf("ack error (Save): %v", err)
+		}
+		state.newest = newest
+		// posts at exactly the checkpoint are polled again, so keep them for dedupe
+		for uri, deliveredAt := range state.delivered {
+			if deliveredAt.Before(newest) {
+				delete(state.delivered, uri)
+			}
+		}
+	}
+	return nil
+}
+
+// Forget that the posts were delivered after a handler failed, so they are delivered again with the next poll.
+func (s *searchCheckpoint) retry(hits []*SearchHit) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.forget(hits)
+	for _, hit := range hits {
+		if state, ok := s.states[hit.Query]; ok {
+			state.retrying[hit.Post.Uri] = hit.IndexedAt
+		}
+	}
+}
+
+// Forget that the posts were delivered. Must be called with the mutex held.
+func (s *searchCheckpoint) forget(hits []*SearchHit) {
+	for _, hit := range hits {
+		if state, ok := s.states[hit.Query]; ok {
+			delete(state.delivered, hit.Post.Uri)
+		}
+	}
+}
+
+// Load the checkpoint of a query from the store. If there is none yet, the query starts now.
+func (s *searchCheckpoint) load(ctx context.Context, state *queryState) error {
+	key := s.prefix + state.query.Name
+	value, err := s.store.Load(ctx, key)
+	if err != nil {
+		return fmt.Errorf("load error (Load): %v", err)
+	}
+	if value == "" {
+		state.newest = time.Now()
+		if err := s.store.Save(ctx, key, state.newest.UTC().Format(time.RFC3339Nano)); err != nil {
+			return fmt.Errorf("load error (Save): %v", err)
+		}
+	} else {
+		newest, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return fmt.Errorf("load error (Parse): %v", err)
+		}
+		state.newest = newest
+	}
+	state.loaded = true
+	return nil
+}
@@ -0,0 +1,74 @@
+package listeners
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davhofer/botsky/pkg/botsky"
+)
+
+func TestSearchPollKeepsHitsWhenAQueryFails(t *testing.T) {
+	indexedAt := time.Now().Add(time.Minute).UTC().Format(time.RFC3339Nano)
+	var mutex sync.Mutex
+	brokenCalls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/xrpc/app.bsky.feed.searchPosts" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("q") == "broken" {
+			mutex.Lock()
+			brokenCalls++
+			failing := brokenCalls <= 10
+			mutex.Unlock()
+			if failing {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "InternalServerError"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"posts": []any{}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"posts": []any{map[string]any{
+			"uri":       "at://did:plc:author/app.bsky.feed.post/1",
+			"cid":       "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm",
+			"author":    map[string]any{"did": "did:plc:author", "handle": "author.test"},
+			"record":    map[string]any{"$type": "app.bsky.feed.post", "text": "hello", "createdAt": indexedAt},
+			"indexedAt": indexedAt,
+		}}})
+	}))
+	defer server.Close()
+
+	client, err := botsky.NewClientWithPds(context.Background(), "did:plc:bot", "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewPollingSearchListener(client, nil, SearchQuery{Query: "ok"}, SearchQuery{Query: "broken"})
+
+	// the queries run in random order, so the hit of the working query is found before the failing one at some point
+	for range 10 {
+		if _, err := l.checkpoint.poll(context.Background(), client); err == nil {
+			t.Fatal("poll succeeded although a query failed")
+		}
+	}
+
+	hits, err := l.checkpoint.poll(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].Query != "ok" {
+		t.Fatalf("unexpected hits %v", hits)
+	}
+	hits, err = l.checkpoint.poll(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 0 {
+		t.Fatalf("hit delivered twice")
+	}
+}
@@ -0,0 +1,140 @@
+package listeners
+
+import (
+	"context"
+	"fmt"
+	"github.com/davhofer/botsky/pkg/botsky"
+	"strconv"
+	"time"
+)
+
+// Connection handling shared by the listeners consuming a websocket stream (Jetstream, firehose).
+type streamListener struct {
+	handlerRegistry[RepoEvent]
+	deliveryTracker[RepoEvent]
+	Name       string
+	Client     *botsky.Client
+	lifecycle  lifecycle
+	retryDelay time.Duration // delay before the first retry of failed events, doubled with every further attempt
+
+	// connects and handles events until the connection fails or connCtx is cancelled, reports whether it connected.
+	// Handlers are called with handlerCtx, which stays alive while the listener drains after Stop.
+	consume    func(connCtx context.Context, handlerCtx context.Context) (bool, error)
+	loadCursor func(context.Context) error // called once before connecting
+	saveCursor func(context.Context) error // called after the listener stopped
+}
+
+func newStreamListener(client *botsky.Client, name string) streamListener {
+	return streamListener{
+		handlerRegistry: newHandlerRegistry[RepoEvent](name),
+		deliveryTracker: newDeliveryTracker(repoEventKey),
+		Name:            name,
+		Client:          client,
+		lifecycle:       lifecycle{name: name},
+		retryDelay:      time.Second,
+	}
+}
+
+// Start listening in the background. This starts a new go routine.
+//
+// The listener runs until Stop is called or ctx is cancelled. Handlers receive a context derived from ctx.
+func (l *streamListener) Start(ctx context.Context) error {
+	return l.lifecycle.start(ctx, l.listen)
+}
+
+// Stop listening. Closes the connection and blocks until all running handlers have returned.
+//
+// If ctx is done before that (e.g. a drain deadline passed), running handlers are cancelled and an error is returned.
+// The listener stays active (and can't be restarted) until they have returned.
+func (l *streamListener) Stop(ctx context.Context) error {
+	return l.lifecycle.stop(ctx)
+}
+
+// Whether the listener is currently running.
+func (l *streamListener) IsActive() bool {
+	return l.lifecycle.isActive()
+}
+
+// Connection loop, reconnecting with exponential backoff until the listener is stopped.
+// Is run as a goroutine.
+func (l *streamListener) listen(ctx context.Context, stop <-chan struct{}) {
+	// closing the connection on stop must not cancel the handlers that are still running
+	connCtx, cancelConn := context.WithCancel(ctx)
+	defer cancelConn()
+	go func() {
+		select {
+		case <-stop:
+			cancelConn()
+		case <-connCtx.Done():
+		}
+	}()
+
+	if err := l.loadCursor(ctx); err != nil {
+		l.reportError("", err)
+	}
+	defer func() {
+		// the cursor must also be saved when the listener is being stopped
+		if err := l.saveCursor(context.WithoutCancel(ctx)); err != nil {
+			l.reportError("", err)
+		}
+	}()
+
+	backoff := time.Second
+	for {
+		connected, err := l.consume(connCtx, ctx)
+		if connCtx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = time.Second
+		}
+		l.reportError("", fmt.Errorf("connection error, reconnecting in %v: %v", backoff, err))
+
+		select {
+		case <-connCtx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+// Pass the events to the handlers. Handlers failing on some of the events get them again, with exponential backoff,
+// until they succeed or the events are given up on (see SetMaxAttempts and DeadLetters). Meanwhile, the stream (or
+// the repo, for the firehose) doesn't move on.
+//
+// Returns false if connCtx is done before the events have been handled; they are delivered again after a restart.
+func (l *streamListener) deliver(connCtx context.Context, ctx context.Context, events []*RepoEvent) bool {
+	delay := l.retryDelay
+	for {
+		// handlers only get the events they haven't handled yet in a previous attempt
+		result := l.dispatch(ctx, l.Client, events, func(id string, i int) bool {
+			return l.handled(events[i], id)
+		})
+		// failures caused by shutting down don't count as attempts
+		_, failed, dead := l.settle(events, result, ctx.Err() == nil)
+		for _, letter := range dead {
+			l.reportError("", fmt.Errorf("giving up on event after %d attempts: %v", letter.Attempts, letter.Err))
+		}
+		if len(failed) == 0 {
+			return true
+		}
+
+		select {
+		case <-connCtx.Done():
+			return false
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxPollBackoff)
+		events = failed
+	}
+}
+
+// Identifies a repo event while it is being delivered.
+func repoEventKey(event *RepoEvent) string {
+	key := event.Kind + " " + event.Did + " " + strconv.FormatInt(event.Time.UnixMicro(), 10)
+	if event.Commit != nil {
+		key += " " + event.Commit.Rev + " " + event.Commit.Collection + "/" + event.Commit.Rkey
+	}
+	return key
+}